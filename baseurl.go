@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// 反代部署在子路径下时，请求进来去掉 base_url，响应中的 Location 和 Emby 地址再改回客户端能访问的地址
+type baseURLContext struct {
+	// 例如 /emby-proxy
+	Path string
+	// 例如 https://host/emby-proxy
+	Public string
+}
+
+type baseURLKey struct{}
+
+// 所有请求都要检查，放在最后执行
+var anyPathRe = regexp.MustCompile(``)
+
+var allMethods = []string{http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete, http.MethodOptions}
+
+// base_url 规范为 /xxx 的形式，未设置时为空
+func (c *Config) basePath() string {
+	base := strings.Trim(c.BaseURL, "/")
+	if base == "" {
+		return ""
+	}
+	return "/" + base
+}
+
+// 去掉请求路径中的 base_url，之后的 hook 和 Emby 看到的都是原始路径
+// 前面的反代已经去掉前缀时原样转发
+func stripBaseURL(req *http.Request) *http.Request {
+	cfg, _ := currentConfig()
+	base := cfg.basePath()
+	if base == "" {
+		return req
+	}
+	path, ok := strings.CutPrefix(req.URL.Path, base)
+	if !ok || (path != "" && path[0] != '/') {
+		path = req.URL.Path
+	} else if rawPath, ok := strings.CutPrefix(req.URL.RawPath, base); ok {
+		req.URL.RawPath = rawPath
+	}
+	if path == "" {
+		path = "/"
+	}
+	req.URL.Path = path
+
+	scheme := "http"
+	if req.TLS != nil {
+		scheme = "https"
+	}
+	if proto := req.Header.Get("X-Forwarded-Proto"); proto != "" {
+		scheme = proto
+	}
+	host := req.Host
+	if forwardedHost := req.Header.Get("X-Forwarded-Host"); forwardedHost != "" {
+		host = forwardedHost
+	}
+	ctx := context.WithValue(req.Context(), baseURLKey{}, baseURLContext{
+		Path:   base,
+		Public: scheme + "://" + host + base,
+	})
+	return req.WithContext(ctx)
+}
+
+// Location 为 Emby 地址时换成客户端访问的地址，为绝对路径时加上 base_url
+func rewriteLocation(location, upstream string, base baseURLContext) string {
+	if rest, ok := strings.CutPrefix(location, upstream); ok && (rest == "" || strings.ContainsAny(rest[:1], "/?")) {
+		return base.Public + rest
+	}
+	if strings.HasPrefix(location, "/") && !strings.HasPrefix(location, "//") && !strings.HasPrefix(location, base.Path+"/") {
+		return base.Path + location
+	}
+	return location
+}
+
+// 改写重定向地址，以及 JSON 响应中的 Emby 地址
+func hookBaseURL(resp *http.Response) error {
+	base, ok := resp.Request.Context().Value(baseURLKey{}).(baseURLContext)
+	if !ok {
+		return nil
+	}
+	cfg, _ := currentConfig()
+	upstream := strings.TrimRight(cfg.EmbyServer, "/")
+	if location := resp.Header.Get("Location"); location != "" {
+		resp.Header.Set("Location", rewriteLocation(location, upstream, base))
+	}
+	if !strings.Contains(resp.Header.Get("Content-Type"), "json") {
+		return nil
+	}
+	body, err := readBody(resp)
+	if err != nil {
+		return err
+	}
+	return writeBody(resp, bytes.ReplaceAll(body, []byte(upstream), []byte(base.Public)))
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// 库里的“类型”“工作室”“标签”“演职人员”标签页
+var browseByNameRe = regexp.MustCompile(`/(Genres|Studios|Tags|Persons)$`)
+
+// 各标签页从条目的哪个字段聚合，以及请求 Emby 时需要的 Fields
+var browseByNameFields = map[string]struct {
+	Field string
+	Param string
+}{
+	"Genres":  {"GenreItems", "Genres"},
+	"Studios": {"Studios", "Studios"},
+	"Tags":    {"TagItems", "Tags"},
+	"Persons": {"People", "People"},
+}
+
+// 条目类型 -> 计数字段
+var itemCountFields = map[string]string{
+	"Movie":      "MovieCount",
+	"Series":     "SeriesCount",
+	"Episode":    "EpisodeCount",
+	"Audio":      "SongCount",
+	"MusicAlbum": "AlbumCount",
+	"MusicVideo": "MusicVideoCount",
+	"Trailer":    "TrailerCount",
+	"Game":       "GameCount",
+}
+
+// 这些参数作用于类型、工作室等名称本身，不能转发给条目查询
+var browseByNameParams = []string{"StartIndex", "Limit", "SortBy", "SortOrder", "Fields", "NameStartsWith", "NameStartsWithOrGreater", "NameLessThan", "SearchTerm", "PersonTypes"}
+
+type namedEntry struct {
+	Name   string
+	Id     string
+	Counts map[string]int
+	Total  int
+}
+
+var browseByNameCache = newTTLCache[[]*namedEntry](5 * time.Minute)
+
+// 聚合库中条目的类型、工作室、标签或演职人员，按库、用户和查询缓存
+func libraryNamedEntries(lib *Library, kind string, orignalReq *http.Request) []*namedEntry {
+	req := withoutClientParams(orignalReq, browseByNameParams...)
+	personTypes := splitList(queryGet(orignalReq.URL.Query(), "PersonTypes"))
+	cacheKey := strings.Join([]string{lib.VirtualID(), kind, getUserId(req), strings.Join(personTypes, ","), req.URL.RawQuery}, "|")
+	if entries, ok := browseByNameCache.Load(cacheKey); ok {
+		return entries
+	}
+	extQuery := url.Values{}
+	extQuery.Set("Fields", browseByNameFields[kind].Param)
+	extQuery.Set("EnableImages", "false")
+	extQuery.Set("EnableUserData", "false")
+	items, _ := getItems(*lib, req, extQuery)["Items"].([]interface{})
+	entries := buildNamedEntries(items, kind, personTypes)
+	browseByNameCache.Store(cacheKey, entries)
+	log.Debugf("library %s %s: %d from %d items", lib.Name, kind, len(entries), len(items))
+	return entries
+}
+
+// 按名称排序的类型、工作室等及其在条目中出现的次数，personTypes 不为空时只统计这些职位的人员
+func buildNamedEntries(items []interface{}, kind string, personTypes []string) []*namedEntry {
+	spec := browseByNameFields[kind]
+	byId := map[string]*namedEntry{}
+	var entries []*namedEntry
+	for _, raw := range items {
+		item, _ := raw.(map[string]interface{})
+		itemType, _ := item["Type"].(string)
+		seen := map[string]bool{}
+		list, _ := item[spec.Field].([]interface{})
+		for _, v := range list {
+			m, _ := v.(map[string]interface{})
+			name, _ := m["Name"].(string)
+			id := toString(m["Id"])
+			if name == "" || id == "" || seen[id] {
+				continue
+			}
+			if personType, _ := m["Type"].(string); len(personTypes) > 0 && !containsFold(personTypes, personType) {
+				continue
+			}
+			seen[id] = true
+			entry, ok := byId[id]
+			if !ok {
+				entry = &namedEntry{Name: name, Id: id, Counts: map[string]int{}}
+				byId[id] = entry
+				entries = append(entries, entry)
+			}
+			entry.Total++
+			if field, ok := itemCountFields[itemType]; ok {
+				entry.Counts[field]++
+			}
+		}
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		return strings.ToLower(entries[i].Name) < strings.ToLower(entries[j].Name)
+	})
+	return entries
+}
+
+func containsFold(list []string, s string) bool {
+	for _, v := range list {
+		if strings.EqualFold(v, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// 按名称过滤，语义同 Emby 的 NameStartsWith、NameStartsWithOrGreater、NameLessThan 和 SearchTerm
+func filterNamedEntries(entries []*namedEntry, query url.Values) []*namedEntry {
+	startsWith := strings.ToLower(queryGet(query, "NameStartsWith"))
+	orGreater := strings.ToLower(queryGet(query, "NameStartsWithOrGreater"))
+	lessThan := strings.ToLower(queryGet(query, "NameLessThan"))
+	searchTerm := strings.ToLower(queryGet(query, "SearchTerm"))
+	result := make([]*namedEntry, 0, len(entries))
+	for _, entry := range entries {
+		name := strings.ToLower(entry.Name)
+		if startsWith != "" && !strings.HasPrefix(name, startsWith) {
+			continue
+		}
+		if orGreater != "" && name < orGreater {
+			continue
+		}
+		if lessThan != "" && name >= lessThan {
+			continue
+		}
+		if searchTerm != "" && !strings.Contains(name, searchTerm) {
+			continue
+		}
+		result = append(result, entry)
+	}
+	return result
+}
+
+// 名称已按升序排好，SortOrder=Descending 时倒序，再按 StartIndex 和 Limit 分页
+func pageNamedEntries(entries []*namedEntry, query url.Values) map[string]interface{} {
+	descending := strings.EqualFold(queryGet(query, "SortOrder"), "Descending")
+	list := make([]interface{}, len(entries))
+	for i, entry := range entries {
+		if descending {
+			list[len(entries)-1-i] = entry
+		} else {
+			list[i] = entry
+		}
+	}
+	return pageItems(list, queryGet(query, "StartIndex"), queryGet(query, "Limit"))
+}
+
+// 虚拟库中的 /Genres、/Studios、/Tags、/Persons：在代理内聚合条目，
+// 当前页的条目再从 Emby 取完整信息（图片等），计数为库内的条目数
+func hookBrowseByName(resp *http.Response) error {
+	kind := browseByNameRe.FindStringSubmatch(resp.Request.URL.Path)[1]
+	log.Debug("hookBrowseByName ", kind)
+	parentId := queryGet(resp.Request.URL.Query(), "ParentId")
+	cfg, libs := currentConfig()
+	lib, ok := lookupLibrary(libs, parentId)
+	if !ok {
+		return nil
+	}
+	if !libraryVisible(cfg, &lib, resp.Request) {
+		return refuseResponse(resp)
+	}
+	orignalQuery := resp.Request.URL.Query()
+	entries := filterNamedEntries(libraryNamedEntries(&lib, kind, resp.Request), orignalQuery)
+	page := pageNamedEntries(entries, orignalQuery)
+	pageEntries, _ := page["Items"].([]interface{})
+
+	var ids []string
+	for _, e := range pageEntries {
+		ids = append(ids, e.(*namedEntry).Id)
+	}
+	dtos := map[string]map[string]interface{}{}
+	if len(ids) > 0 {
+		query := url.Values{}
+		query.Set("Ids", strings.Join(ids, ","))
+		if fields := queryGet(orignalQuery, "Fields"); fields != "" {
+			query.Set("Fields", fields)
+		}
+		data := fetchUserItems(query, resp.Request)
+		found, _ := data["Items"].([]interface{})
+		for _, raw := range found {
+			if m, ok := raw.(map[string]interface{}); ok {
+				dtos[itemId(m)] = m
+			}
+		}
+	}
+	items := make([]interface{}, 0, len(pageEntries))
+	for _, e := range pageEntries {
+		entry := e.(*namedEntry)
+		dto, ok := dtos[entry.Id]
+		if !ok {
+			// Emby 查不到时只返回名称和 id，客户端仍可以点进去
+			dto = map[string]interface{}{
+				"Name":      entry.Name,
+				"Id":        entry.Id,
+				"Type":      strings.TrimSuffix(kind, "s"),
+				"ImageTags": map[string]interface{}{},
+			}
+		}
+		dto["ChildCount"] = entry.Total
+		for field, count := range entry.Counts {
+			dto[field] = count
+		}
+		items = append(items, dto)
+	}
+	return replaceJSON(resp, map[string]interface{}{
+		"Items":            items,
+		"TotalRecordCount": page["TotalRecordCount"],
+	})
+}
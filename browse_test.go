@@ -0,0 +1,145 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// 按 TagIds 返回带类型和演职人员的条目的假 Emby
+func fakeBrowseFetch(query url.Values) map[string]interface{} {
+	genre := func(name, id string) map[string]interface{} { return map[string]interface{}{"Name": name, "Id": id} }
+	person := func(name, id, personType string) map[string]interface{} {
+		return map[string]interface{}{"Name": name, "Id": id, "Type": personType}
+	}
+	all := []map[string]interface{}{
+		{"Id": "m1", "Type": "Movie", "Tag": "t1",
+			"GenreItems": []interface{}{genre("Action", "g1"), genre("Drama", "g2")},
+			"People":     []interface{}{person("Alice", "p1", "Actor"), person("Bob", "p2", "Director")}},
+		{"Id": "m2", "Type": "Movie", "Tag": "t1",
+			"GenreItems": []interface{}{genre("Action", "g1")},
+			"People":     []interface{}{person("Alice", "p1", "Actor"), person("Alice", "p1", "Producer")}},
+		{"Id": "s1", "Type": "Series", "Tag": "t1",
+			"GenreItems": []interface{}{genre("Comedy", "g3"), genre("action", "g1")},
+			"People":     []interface{}{person("Bob", "p2", "Actor")}},
+		{"Id": "m3", "Type": "Movie", "Tag": "t2",
+			"GenreItems": []interface{}{genre("Horror", "g4")}},
+	}
+	items := []interface{}{}
+	for _, item := range all {
+		if item["Tag"] == query.Get("TagIds") {
+			items = append(items, item)
+		}
+	}
+	return map[string]interface{}{"Items": items}
+}
+
+// 名称、总数和各类型计数，例如 Action:3:Movie2:Series1
+func formatNamedEntries(entries []*namedEntry) string {
+	var parts []string
+	for _, entry := range entries {
+		s := fmt.Sprintf("%s:%d", entry.Name, entry.Total)
+		for _, itemType := range []string{"Movie", "Series"} {
+			if count := entry.Counts[itemCountFields[itemType]]; count > 0 {
+				s += fmt.Sprintf(":%s%d", itemType, count)
+			}
+		}
+		parts = append(parts, s)
+	}
+	return strings.Join(parts, ",")
+}
+
+func TestBuildNamedEntries(t *testing.T) {
+	lib := Library{Name: "T1", ResourceType: "tag", ResourceID: "t1"}
+	items := collectItems(&lib, url.Values{}, fakeBrowseFetch)
+	tests := []struct {
+		kind        string
+		personTypes []string
+		want        string
+	}{
+		// 同一条目里重复的 id 只计一次，库外的 Horror 不出现
+		{"Genres", nil, "Action:3:Movie2:Series1,Comedy:1:Series1,Drama:1:Movie1"},
+		{"Persons", nil, "Alice:2:Movie2,Bob:2:Movie1:Series1"},
+		{"Persons", []string{"actor"}, "Alice:2:Movie2,Bob:1:Series1"},
+		{"Persons", []string{"Director", "Producer"}, "Alice:1:Movie1,Bob:1:Movie1"},
+		{"Tags", nil, ""},
+	}
+	for _, tt := range tests {
+		got := formatNamedEntries(buildNamedEntries(items, tt.kind, tt.personTypes))
+		if got != tt.want {
+			t.Errorf("%s %v: got %q, want %q", tt.kind, tt.personTypes, got, tt.want)
+		}
+	}
+}
+
+func testNamedEntries(names ...string) []*namedEntry {
+	var entries []*namedEntry
+	for _, name := range names {
+		entries = append(entries, &namedEntry{Name: name, Id: name})
+	}
+	return entries
+}
+
+func namedEntryNames(entries []*namedEntry) string {
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name)
+	}
+	return strings.Join(names, ",")
+}
+
+func TestFilterNamedEntries(t *testing.T) {
+	entries := testNamedEntries("Action", "Comedy", "Drama", "horror", "Thriller")
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{"", "Action,Comedy,Drama,horror,Thriller"},
+		{"NameStartsWith=c", "Comedy"},
+		{"NameStartsWith=H", "horror"},
+		{"NameStartsWithOrGreater=d", "Drama,horror,Thriller"},
+		{"NameLessThan=D", "Action,Comedy"},
+		{"NameStartsWithOrGreater=c&NameLessThan=i", "Comedy,Drama,horror"},
+		{"SearchTerm=RA", "Drama"},
+	}
+	for _, tt := range tests {
+		query, _ := url.ParseQuery(tt.query)
+		if got := namedEntryNames(filterNamedEntries(entries, query)); got != tt.want {
+			t.Errorf("%q: got %q, want %q", tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestPageNamedEntries(t *testing.T) {
+	entries := testNamedEntries("Action", "Comedy", "Drama", "Horror", "Thriller")
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{"", "Action,Comedy,Drama,Horror,Thriller"},
+		{"StartIndex=1&Limit=2", "Comedy,Drama"},
+		{"SortOrder=Descending", "Thriller,Horror,Drama,Comedy,Action"},
+		{"SortOrder=descending&StartIndex=1&Limit=2", "Horror,Drama"},
+		{"SortOrder=Descending&StartIndex=4&Limit=2", "Action"},
+	}
+	for _, tt := range tests {
+		query, _ := url.ParseQuery(tt.query)
+		page := pageNamedEntries(entries, query)
+		list, _ := page["Items"].([]interface{})
+		var got []*namedEntry
+		for _, e := range list {
+			got = append(got, e.(*namedEntry))
+		}
+		if names := namedEntryNames(got); names != tt.want {
+			t.Errorf("%q: got %q, want %q", tt.query, names, tt.want)
+		}
+		if total := page["TotalRecordCount"]; total != len(entries) {
+			t.Errorf("%q: TotalRecordCount = %v, want %d", tt.query, total, len(entries))
+		}
+	}
+	// 逆序不改变缓存中的顺序
+	if names := namedEntryNames(entries); names != "Action,Comedy,Drama,Horror,Thriller" {
+		t.Errorf("entries reordered: %q", names)
+	}
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// 带过期时间的缓存，写入时顺带清理过期条目
+type ttlCache[V any] struct {
+	ttl time.Duration
+	m   sync.Map
+}
+
+type ttlCacheEntry[V any] struct {
+	value   V
+	expires time.Time
+}
+
+func newTTLCache[V any](ttl time.Duration) *ttlCache[V] {
+	return &ttlCache[V]{ttl: ttl}
+}
+
+func (c *ttlCache[V]) Load(key string) (V, bool) {
+	if v, ok := c.m.Load(key); ok {
+		entry := v.(*ttlCacheEntry[V])
+		if time.Now().Before(entry.expires) {
+			return entry.value, true
+		}
+	}
+	var zero V
+	return zero, false
+}
+
+func (c *ttlCache[V]) Store(key string, value V) {
+	now := time.Now()
+	c.m.Range(func(k, v interface{}) bool {
+		if now.After(v.(*ttlCacheEntry[V]).expires) {
+			c.m.Delete(k)
+		}
+		return true
+	})
+	c.m.Store(key, &ttlCacheEntry[V]{value: value, expires: now.Add(c.ttl)})
+}
+
+func (c *ttlCache[V]) Clear() {
+	c.m.Clear()
+}
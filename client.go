@@ -0,0 +1,115 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// ClientProfile 描述某个客户端的特殊处理，按客户端名称或 User-Agent 匹配
+type ClientProfile struct {
+	Name string `yaml:"name"`
+	// X-Emby-Client 或认证头中的 Client，不区分大小写完全匹配
+	Clients []string `yaml:"clients"`
+	// User-Agent 包含其中任意一个即匹配，不区分大小写
+	UserAgents []string `yaml:"user_agents"`
+	// 按 hook 名称启用或关闭，例如 items-as-views: true
+	Hooks map[string]bool `yaml:"hooks"`
+	// 在虚拟库视图上添加或覆盖的字段
+	ViewFields map[string]interface{} `yaml:"view_fields"`
+	// 从虚拟库视图上删除的字段
+	StripFields []string `yaml:"strip_fields"`
+}
+
+// 内置的客户端配置，配置文件中的 client_profiles 优先
+var builtinClientProfiles = []ClientProfile{
+	{
+		// 网易爆米花通过不带 *Id 参数的 /Users/{id}/Items 获取库列表
+		Name:       "netease-popcorn",
+		Clients:    []string{"网易爆米花"},
+		UserAgents: []string{"爆米花"},
+		Hooks:      map[string]bool{"items-as-views": true},
+	},
+}
+
+// 没有匹配的客户端配置时使用，所有默认启用的 hook 都执行
+var defaultClientProfile = &ClientProfile{Name: "default"}
+
+// 客户端名称依次取 X-Emby-Client 头、同名查询参数和认证头中的 Client
+func clientName(req *http.Request) string {
+	if name := req.Header.Get("X-Emby-Client"); name != "" {
+		return name
+	}
+	if name := queryGet(req.URL.Query(), "X-Emby-Client"); name != "" {
+		return name
+	}
+	return authHeaderParam(req, "Client")
+}
+
+func (p *ClientProfile) Match(client, userAgent string) bool {
+	if client != "" && containsFold(p.Clients, client) {
+		return true
+	}
+	userAgent = strings.ToLower(userAgent)
+	for _, ua := range p.UserAgents {
+		if ua != "" && strings.Contains(userAgent, strings.ToLower(ua)) {
+			return true
+		}
+	}
+	return false
+}
+
+// 当前请求的客户端配置，先查配置文件再查内置的
+func clientProfileFor(req *http.Request) *ClientProfile {
+	cfg, _ := currentConfig()
+	client, userAgent := clientName(req), req.Header.Get("User-Agent")
+	for _, profiles := range [][]ClientProfile{cfg.ClientProfiles, builtinClientProfiles} {
+		for i := range profiles {
+			if profiles[i].Match(client, userAgent) {
+				return &profiles[i]
+			}
+		}
+	}
+	return defaultClientProfile
+}
+
+// 客户端配置中显式启用或关闭的 hook 以配置为准，否则只执行默认启用的 hook
+func (p *ClientProfile) runs(hook Hook) bool {
+	if enabled, ok := p.Hooks[hookName(hook)]; ok {
+		return enabled
+	}
+	if h, ok := hook.(*RouteHook); ok {
+		return !h.OptIn
+	}
+	return true
+}
+
+// 按客户端调整虚拟库视图的字段
+func (p *ClientProfile) adaptView(item map[string]interface{}) {
+	for key, value := range p.ViewFields {
+		item[key] = value
+	}
+	for _, key := range p.StripFields {
+		delete(item, key)
+	}
+}
+
+func clientProfileErrors(p *ClientProfile, where string) []error {
+	var errs []error
+	if p.Name == "" {
+		errs = append(errs, fmt.Errorf("%s: name is empty", where))
+	}
+	if len(p.Clients) == 0 && len(p.UserAgents) == 0 {
+		errs = append(errs, fmt.Errorf("%s: clients or user_agents is required", where))
+	}
+	names := map[string]bool{}
+	for _, hook := range hooks {
+		names[hookName(hook)] = true
+	}
+	for name := range p.Hooks {
+		if !names[name] {
+			errs = append(errs, fmt.Errorf("%s: unknown hook %q", where, name))
+		}
+	}
+	return errs
+}
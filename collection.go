@@ -0,0 +1,120 @@
+package main
+
+import (
+	"net/url"
+	"slices"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// 不同 CollectionType 的虚拟库在客户端的展示方式
+type collectionProfile struct {
+	Subviews                []string
+	IncludeItemTypes        string
+	PrimaryImageAspectRatio float64
+}
+
+var collectionProfiles = map[string]collectionProfile{
+	"movies":     {[]string{"movies", "collections", "genres", "studios", "folders"}, "Movie", 16.0 / 9},
+	"tvshows":    {[]string{"series", "studios", "genres", "episodes", "folders"}, "Series", 16.0 / 9},
+	"music":      {[]string{"musicalbums", "albumartists", "artists", "songs", "genres", "folders"}, "MusicAlbum", 1},
+	"homevideos": {[]string{"videos", "photos", "folders"}, "Video,Photo", 16.0 / 9},
+	"books":      {[]string{"books", "folders"}, "Book", 16.0 / 9},
+	// 为了过滤掉非电影、电视剧、视频、游戏、音乐专辑、剧集的资源，比如合集、播放列表等，主要是以原生流派为数据源时会出现
+	"mixed": {[]string{"folders"}, "Movie,Series,Video,Game,MusicAlbum,Episode", 16.0 / 9},
+}
+
+// 未设置 collection_type 的库推断出的类型，key 为库名
+var inferredCollectionTypes sync.Map
+
+// 库的 CollectionType，未配置时使用推断结果，推断完成前为 mixed
+func (l *Library) collectionType() string {
+	if l.CollectionType != "" {
+		return l.CollectionType
+	}
+	if t, ok := inferredCollectionTypes.Load(l.Name); ok {
+		return t.(string)
+	}
+	return "mixed"
+}
+
+func (l *Library) collectionProfile() collectionProfile {
+	return collectionProfiles[l.collectionType()]
+}
+
+// 根据条目类型推断 CollectionType，混有多种类型时为 mixed
+func inferCollectionType(types []string) string {
+	if len(types) == 0 {
+		return "mixed"
+	}
+	only := func(allowed ...string) bool {
+		for _, t := range types {
+			if !slices.Contains(allowed, t) {
+				return false
+			}
+		}
+		return true
+	}
+	switch {
+	case only("Movie"):
+		return "movies"
+	// 只有剧集没有剧集所属的电视剧时，按电视剧展示会是空的
+	case only("Series", "Season", "Episode") && slices.Contains(types, "Series"):
+		return "tvshows"
+	case only("MusicAlbum", "Audio", "MusicArtist"):
+		return "music"
+	case only("Book"):
+		return "books"
+	case only("Video", "Photo"):
+		return "homevideos"
+	}
+	return "mixed"
+}
+
+// 采样库中的条目类型推断 CollectionType，没有 API Key 时只根据 query 中的 IncludeItemTypes 推断
+func detectCollectionType(lib *Library, apiKey string) string {
+	if v := lib.Query["IncludeItemTypes"]; v != "" {
+		return inferCollectionType(splitList(v))
+	}
+	if apiKey == "" {
+		return "mixed"
+	}
+	query := url.Values{}
+	query.Set("IncludeItemTypes", collectionProfiles["mixed"].IncludeItemTypes)
+	query.Set("EnableImages", "false")
+	query.Set("EnableUserData", "false")
+	lib.applySource(query)
+	fetch := func(q url.Values) map[string]interface{} {
+		q.Set("Limit", "200")
+		return fetchApiItems(q, apiKey)
+	}
+	var items []interface{}
+	if lib.IsLocal() {
+		items = collectItems(lib, query, fetch)
+	} else {
+		items, _ = fetch(query)["Items"].([]interface{})
+	}
+	var types []string
+	for _, raw := range items {
+		item, _ := raw.(map[string]interface{})
+		if t, ok := item["Type"].(string); ok && !slices.Contains(types, t) {
+			types = append(types, t)
+		}
+	}
+	return inferCollectionType(types)
+}
+
+// 异步推断未配置 collection_type 的库的类型
+func detectCollectionTypes(libs []Library, apiKey string) {
+	for _, lib := range libs {
+		if lib.CollectionType != "" {
+			continue
+		}
+		go func(l Library) {
+			t := detectCollectionType(&l, apiKey)
+			log.Debugf("library %s collection type inferred as %s", l.Name, t)
+			inferredCollectionTypes.Store(l.Name, t)
+		}(lib)
+	}
+}
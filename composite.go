@@ -0,0 +1,152 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// composite 库由多个来源组合而成，在代理内完成集合运算
+func (l *Library) IsComposite() bool {
+	return l.ResourceType == "composite"
+}
+
+// 遍历 composite 库的直接来源，path 形如 all[0]
+func (l *Library) walkSources(fn func(src *Library, path string)) {
+	for _, group := range []struct {
+		name    string
+		sources []Library
+	}{{"all", l.All}, {"any", l.Any}, {"none", l.None}} {
+		for i := range group.sources {
+			fn(&group.sources[i], fmt.Sprintf("%s[%d]", group.name, i))
+		}
+	}
+}
+
+// 拉取来源的全部条目，composite 来源递归计算，有规则时再按规则过滤
+func collectItems(lib *Library, query url.Values, fetch itemsFetcher) []interface{} {
+	var items []interface{}
+	if lib.IsComposite() {
+		items = compositeItems(lib, query, fetch)
+	} else {
+		q := cloneValues(query)
+		lib.applySource(q)
+		items, _ = fetch(q)["Items"].([]interface{})
+	}
+	if lib.Rule == "" {
+		return items
+	}
+	rule, err := compileRule(lib.Rule)
+	if err != nil {
+		// 配置校验时已经编译过，不会走到这里
+		return nil
+	}
+	result := make([]interface{}, 0, len(items))
+	for _, item := range items {
+		if m, ok := item.(map[string]interface{}); ok && rule.Match(m) {
+			result = append(result, item)
+		}
+	}
+	return result
+}
+
+// 只需要 id 做集合运算的来源不拉取额外字段，规则求值需要字段时除外
+func collectItemIds(lib *Library, query url.Values, fetch itemsFetcher) map[string]bool {
+	q := cloneValues(query)
+	if len(lib.ruleFieldParams()) == 0 && !lib.hasRule() {
+		q.Del("Fields")
+		q.Set("EnableImages", "false")
+		q.Set("EnableUserData", "false")
+	}
+	return itemIdSet(collectItems(lib, q, fetch))
+}
+
+// (all 的交集) ∩ (any 的并集) - (none 的并集)，all 为空时只取 any 的并集
+func compositeItems(lib *Library, query url.Values, fetch itemsFetcher) []interface{} {
+	var result []interface{}
+	if len(lib.All) > 0 {
+		result = collectItems(&lib.All[0], query, fetch)
+		for i := 1; i < len(lib.All) && len(result) > 0; i++ {
+			ids := collectItemIds(&lib.All[i], query, fetch)
+			result = filterItems(result, func(id string) bool { return ids[id] })
+		}
+	}
+	if len(lib.Any) > 0 {
+		if len(lib.All) > 0 {
+			ids := map[string]bool{}
+			for i := range lib.Any {
+				for id := range collectItemIds(&lib.Any[i], query, fetch) {
+					ids[id] = true
+				}
+			}
+			result = filterItems(result, func(id string) bool { return ids[id] })
+		} else {
+			seen := map[string]bool{}
+			for i := range lib.Any {
+				for _, item := range collectItems(&lib.Any[i], query, fetch) {
+					id := itemId(item)
+					if !seen[id] {
+						seen[id] = true
+						result = append(result, item)
+					}
+				}
+			}
+		}
+	}
+	for i := range lib.None {
+		if len(result) == 0 {
+			break
+		}
+		ids := collectItemIds(&lib.None[i], query, fetch)
+		result = filterItems(result, func(id string) bool { return !ids[id] })
+	}
+	return result
+}
+
+// 可以取交集的多值筛选参数，Emby 对同一参数的多个值取并集
+var intersectableParams = []string{"TagIds", "GenreIds", "StudioIds", "PersonIds", "ArtistIds", "Tags", "Genres", "Studios", "Years", "OfficialRatings"}
+
+// 库及其来源为 key 设置的值
+func (l *Library) sourceParams(key string) []string {
+	var values []string
+	if strings.EqualFold(l.GetParamKey(), key) && l.ResourceID != "" {
+		values = append(values, l.ResourceID)
+	}
+	for k, v := range l.Query {
+		if strings.EqualFold(k, key) {
+			values = append(values, v)
+		}
+	}
+	l.walkSources(func(src *Library, _ string) {
+		values = append(values, src.sourceParams(key)...)
+	})
+	return values
+}
+
+// 客户端的筛选和库自身的条件作用于同一参数时（例如在 tag 库里按另一个标签筛选），
+// applySource 会覆盖客户端的值，这时改为 composite 库：客户端的筛选 ∩ 库，在代理内取交集
+func (l *Library) withClientFilters(query url.Values) Library {
+	filter := ItemQuery{}
+	for _, key := range intersectableParams {
+		value := queryGet(query, key)
+		if value == "" {
+			continue
+		}
+		for _, v := range l.sourceParams(key) {
+			if v != value {
+				filter[key] = value
+				break
+			}
+		}
+	}
+	if len(filter) == 0 {
+		return *l
+	}
+	// 客户端的筛选通常范围更小，放在前面拉取完整条目，库只取 id
+	return Library{
+		Name:         l.Name,
+		ID:           l.VirtualID(),
+		ResourceType: "composite",
+		All:          []Library{{ResourceType: "query", Query: filter}, *l},
+	}
+}
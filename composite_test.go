@@ -0,0 +1,92 @@
+package main
+
+import (
+	"net/url"
+	"slices"
+	"testing"
+)
+
+// 按 TagIds 返回条目的假 Emby
+func fakeTagFetch(query url.Values) map[string]interface{} {
+	byTag := map[string][]string{
+		"t1": {"a", "b", "c"},
+		"t2": {"b", "c", "d"},
+		"t3": {"d", "e"},
+		"t4": {"c"},
+	}
+	items := []interface{}{}
+	for _, id := range byTag[query.Get("TagIds")] {
+		items = append(items, map[string]interface{}{"Id": id, "Name": id})
+	}
+	return map[string]interface{}{"Items": items}
+}
+
+func collectedIds(items []interface{}) []string {
+	var ids []string
+	for _, item := range items {
+		ids = append(ids, itemId(item))
+	}
+	slices.Sort(ids)
+	return ids
+}
+
+func TestCompositeItems(t *testing.T) {
+	tag := func(id string) Library { return Library{ResourceType: "tag", ResourceID: id} }
+	tests := []struct {
+		name string
+		lib  Library
+		want []string
+	}{
+		{"all", Library{All: []Library{tag("t1"), tag("t2")}}, []string{"b", "c"}},
+		{"all empty", Library{All: []Library{tag("t3"), tag("t4")}}, nil},
+		{"any", Library{Any: []Library{tag("t1"), tag("t3")}}, []string{"a", "b", "c", "d", "e"}},
+		{"all and any", Library{All: []Library{tag("t1")}, Any: []Library{tag("t3"), tag("t4")}}, []string{"c"}},
+		{"all and none", Library{All: []Library{tag("t2")}, None: []Library{tag("t4")}}, []string{"b", "d"}},
+		{"any and none", Library{Any: []Library{tag("t1"), tag("t3")}, None: []Library{tag("t2")}}, []string{"a", "e"}},
+		{"nested", Library{All: []Library{{ResourceType: "composite", Any: []Library{tag("t1"), tag("t3")}}}, None: []Library{tag("t4")}}, []string{"a", "b", "d", "e"}},
+		{"rule", Library{All: []Library{tag("t1")}, Rule: `Name != "b"`}, []string{"a", "c"}},
+	}
+	for _, tt := range tests {
+		tt.lib.ResourceType = "composite"
+		got := collectedIds(collectItems(&tt.lib, url.Values{}, fakeTagFetch))
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+// 在 tag 库里按另一个标签筛选时，结果是两个标签的交集而不是被库的标签覆盖
+func TestClientFilterIntersectsLibrary(t *testing.T) {
+	tests := []struct {
+		name  string
+		lib   Library
+		query url.Values
+		want  []string
+	}{
+		{"same key", Library{Name: "T1", ResourceType: "tag", ResourceID: "t1"}, url.Values{"TagIds": {"t2"}}, []string{"b", "c"}},
+		{"same value", Library{Name: "T1", ResourceType: "tag", ResourceID: "t1"}, url.Values{"TagIds": {"t1"}}, []string{"a", "b", "c"}},
+		{"composite source", Library{Name: "C", ResourceType: "composite", Any: []Library{
+			{ResourceType: "tag", ResourceID: "t1"},
+		}}, url.Values{"TagIds": {"t2"}}, []string{"b", "c"}},
+		{"query library", Library{Name: "Q", ResourceType: "query", Query: ItemQuery{"TagIds": "t2"}}, url.Values{"TagIds": {"t1"}}, []string{"b", "c"}},
+	}
+	for _, tt := range tests {
+		lib := tt.lib.withClientFilters(tt.query)
+		got := collectedIds(collectItems(&lib, tt.query, fakeTagFetch))
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestClientFilterKeepsLibraryId(t *testing.T) {
+	lib := Library{Name: "T1", ResourceType: "tag", ResourceID: "t1"}
+	wrapped := lib.withClientFilters(url.Values{"TagIds": {"t2"}})
+	if !wrapped.IsComposite() || wrapped.VirtualID() != lib.VirtualID() {
+		t.Errorf("withClientFilters() = %+v, want composite with id %s", wrapped, lib.VirtualID())
+	}
+	// 参数不冲突时交给 Emby 处理
+	if other := lib.withClientFilters(url.Values{"GenreIds": {"g1"}}); other.IsComposite() {
+		t.Errorf("withClientFilters() with GenreIds = %+v, want unchanged", other)
+	}
+}
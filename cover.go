@@ -0,0 +1,312 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	_ "image/jpeg"
+	"image/png"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/image/draw"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/gobold"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/font/sfnt"
+	"golang.org/x/image/math/f64"
+	"golang.org/x/image/math/fixed"
+	_ "golang.org/x/image/webp"
+)
+
+// 封面为 16:9，右侧是倾斜的 3x3 海报墙，左侧是库名
+const (
+	coverWidth   = 960
+	coverHeight  = 540
+	coverPosters = 9
+	// 海报墙的倾斜角度
+	coverAngle = -12 * math.Pi / 180
+	posterW    = 150
+	posterH    = 225
+	posterGap  = 16
+)
+
+// 没有配置 cover_font 或其中缺字时依次尝试的系统字体，Docker 镜像中安装了 font-noto-cjk
+var systemCoverFonts = []string{
+	"/usr/share/fonts/noto/NotoSansCJK-Bold.ttc",
+	"/usr/share/fonts/noto/NotoSansCJK-Regular.ttc",
+	"/usr/share/fonts/opentype/noto/NotoSansCJK-Bold.ttc",
+	"/usr/share/fonts/opentype/noto/NotoSansCJK-Regular.ttc",
+	"/usr/share/fonts/wenquanyi/wqy-zenhei/wqy-zenhei.ttc",
+	"/usr/share/fonts/truetype/wqy/wqy-zenhei.ttc",
+}
+
+// cover_font -> 解析好的字体列表，CJK 字体较大，只加载一次
+var coverFontsCache sync.Map
+
+// 字体按优先级排列，最后一个是内置的 Go Bold，只有拉丁字符
+func loadCoverFonts(path string) []*sfnt.Font {
+	if fonts, ok := coverFontsCache.Load(path); ok {
+		return fonts.([]*sfnt.Font)
+	}
+	var fonts []*sfnt.Font
+	paths := systemCoverFonts
+	if path != "" {
+		paths = append([]string{path}, paths...)
+	}
+	for _, p := range paths {
+		data, err := os.ReadFile(p)
+		if err != nil {
+			if p == path {
+				log.Warn("read cover_font error ", err)
+			}
+			continue
+		}
+		// ParseCollection 同时支持 .ttf/.otf 和 .ttc
+		collection, err := opentype.ParseCollection(data)
+		if err != nil {
+			log.Warnf("parse font %s error %v", p, err)
+			continue
+		}
+		f, err := collection.Font(0)
+		if err != nil {
+			log.Warnf("parse font %s error %v", p, err)
+			continue
+		}
+		fonts = append(fonts, f)
+	}
+	builtin, err := opentype.Parse(gobold.TTF)
+	if err == nil {
+		fonts = append(fonts, builtin)
+	}
+	coverFontsCache.Store(path, fonts)
+	return fonts
+}
+
+// 逐字选择含有该字形的字体，库名可能中英文混排
+type coverText struct {
+	fonts []*sfnt.Font
+	faces []font.Face
+	buf   sfnt.Buffer
+}
+
+func newCoverText(fonts []*sfnt.Font, size float64) (*coverText, error) {
+	t := &coverText{fonts: fonts}
+	for _, f := range fonts {
+		face, err := opentype.NewFace(f, &opentype.FaceOptions{Size: size, DPI: 72, Hinting: font.HintingFull})
+		if err != nil {
+			return nil, err
+		}
+		t.faces = append(t.faces, face)
+	}
+	if len(t.faces) == 0 {
+		return nil, fmt.Errorf("no font available for cover")
+	}
+	return t, nil
+}
+
+func (t *coverText) Close() {
+	for _, face := range t.faces {
+		face.Close()
+	}
+}
+
+func (t *coverText) faceFor(r rune) font.Face {
+	for i, f := range t.fonts {
+		if idx, err := f.GlyphIndex(&t.buf, r); err == nil && idx != 0 {
+			return t.faces[i]
+		}
+	}
+	return t.faces[len(t.faces)-1]
+}
+
+func (t *coverText) measure(s string) fixed.Int26_6 {
+	var width fixed.Int26_6
+	for _, r := range s {
+		advance, _ := t.faceFor(r).GlyphAdvance(r)
+		width += advance
+	}
+	return width
+}
+
+// 按宽度折行，优先在空格处断开，中文等没有空格的文字逐字断开
+func (t *coverText) wrap(s string, maxWidth fixed.Int26_6) []string {
+	var lines []string
+	var line []rune
+	for _, word := range strings.SplitAfter(s, " ") {
+		// 行尾的空格不计入宽度
+		if t.measure(strings.TrimRight(string(line)+word, " ")) <= maxWidth {
+			line = append(line, []rune(word)...)
+			continue
+		}
+		if len(line) > 0 && t.measure(strings.TrimRight(word, " ")) <= maxWidth {
+			lines = append(lines, strings.TrimSpace(string(line)))
+			line = []rune(word)
+			continue
+		}
+		for _, r := range word {
+			if len(line) > 0 && t.measure(string(append(line, r))) > maxWidth {
+				lines = append(lines, strings.TrimSpace(string(line)))
+				line = nil
+			}
+			line = append(line, r)
+		}
+	}
+	if s := strings.TrimSpace(string(line)); s != "" {
+		lines = append(lines, s)
+	}
+	return lines
+}
+
+func (t *coverText) draw(dst draw.Image, s string, x, baseline int, c color.Color) {
+	dot := fixed.P(x, baseline)
+	for _, r := range s {
+		d := &font.Drawer{Dst: dst, Src: image.NewUniform(c), Face: t.faceFor(r), Dot: dot}
+		d.DrawString(string(r))
+		dot = d.Dot
+	}
+}
+
+// 所有海报的平均色，压暗后作为背景
+func coverBackground(posters []image.Image) color.RGBA {
+	var r, g, b, n uint64
+	for _, poster := range posters {
+		bounds := poster.Bounds()
+		// 隔点采样即可
+		for y := bounds.Min.Y; y < bounds.Max.Y; y += 8 {
+			for x := bounds.Min.X; x < bounds.Max.X; x += 8 {
+				pr, pg, pb, _ := poster.At(x, y).RGBA()
+				r, g, b, n = r+uint64(pr>>8), g+uint64(pg>>8), b+uint64(pb>>8), n+1
+			}
+		}
+	}
+	if n == 0 {
+		return color.RGBA{40, 40, 48, 255}
+	}
+	return color.RGBA{uint8(r / n * 45 / 100), uint8(g / n * 45 / 100), uint8(b / n * 45 / 100), 255}
+}
+
+// 按 2:3 居中裁剪后缩放到海报格子
+func drawPoster(dst *image.RGBA, rect image.Rectangle, poster image.Image) {
+	src := poster.Bounds()
+	if src.Dx()*posterH > src.Dy()*posterW {
+		w := src.Dy() * posterW / posterH
+		src.Min.X += (src.Dx() - w) / 2
+		src.Max.X = src.Min.X + w
+	} else {
+		h := src.Dx() * posterH / posterW
+		src.Min.Y += (src.Dy() - h) / 2
+		src.Max.Y = src.Min.Y + h
+	}
+	draw.CatmullRom.Scale(dst, rect, poster, src, draw.Src, nil)
+}
+
+// 3x3 海报墙，中间一列错开半张，海报不足 9 张时循环使用
+func posterWall(posters []image.Image) *image.RGBA {
+	wall := image.NewRGBA(image.Rect(0, 0, 3*posterW+2*posterGap, 3*posterH+2*posterGap+posterH/2))
+	for i := 0; i < coverPosters; i++ {
+		col, row := i%3, i/3
+		x := col * (posterW + posterGap)
+		y := row * (posterH + posterGap)
+		if col != 1 {
+			y += posterH / 2
+		}
+		drawPoster(wall, image.Rect(x, y, x+posterW, y+posterH), posters[i%len(posters)])
+	}
+	return wall
+}
+
+// 生成封面：背景、旋转的海报墙、左侧渐变遮罩和库名
+func renderCover(title string, posters []image.Image, fonts []*sfnt.Font) (image.Image, error) {
+	if len(posters) == 0 {
+		return nil, fmt.Errorf("no poster for cover")
+	}
+	bg := coverBackground(posters)
+	canvas := image.NewRGBA(image.Rect(0, 0, coverWidth, coverHeight))
+	draw.Draw(canvas, canvas.Bounds(), image.NewUniform(bg), image.Point{}, draw.Src)
+
+	// 海报墙中心放在画面右侧，绕中心旋转
+	wall := posterWall(posters)
+	wx, wy := float64(wall.Bounds().Dx())/2, float64(wall.Bounds().Dy())/2
+	cx, cy := coverWidth*0.72, coverHeight*0.5
+	cos, sin := math.Cos(coverAngle), math.Sin(coverAngle)
+	m := f64.Aff3{
+		cos, -sin, cx - (cos*wx - sin*wy),
+		sin, cos, cy - (sin*wx + cos*wy),
+	}
+	draw.BiLinear.Transform(canvas, m, wall, wall.Bounds(), draw.Over, nil)
+
+	// 左侧用背景色盖住海报墙，向右渐变为透明，保证文字清晰
+	solid, fade := coverWidth*38/100, coverWidth*62/100
+	for x := 0; x < fade; x++ {
+		alpha := 1.0
+		if x > solid {
+			alpha = 1 - float64(x-solid)/float64(fade-solid)
+		}
+		for y := 0; y < coverHeight; y++ {
+			i := canvas.PixOffset(x, y)
+			pix := canvas.Pix[i : i+3]
+			pix[0] = uint8(float64(pix[0])*(1-alpha) + float64(bg.R)*alpha)
+			pix[1] = uint8(float64(pix[1])*(1-alpha) + float64(bg.G)*alpha)
+			pix[2] = uint8(float64(pix[2])*(1-alpha) + float64(bg.B)*alpha)
+		}
+	}
+
+	// 库名过长时先折成两行，仍放不下再缩小字号，最宽占画面的 40%
+	maxWidth := fixed.I(coverWidth * 40 / 100)
+	size := float64(coverHeight) / 7
+	var text *coverText
+	var lines []string
+	for {
+		var err error
+		text, err = newCoverText(fonts, size)
+		if err != nil {
+			return nil, err
+		}
+		lines = text.wrap(title, maxWidth)
+		if len(lines) <= 2 || size <= 20 {
+			break
+		}
+		text.Close()
+		size *= 0.9
+	}
+	defer text.Close()
+	left := coverWidth * 6 / 100
+	lineHeight := int(size * 1.25)
+	baseline := coverHeight/2 + int(size/3) - lineHeight*(len(lines)-1)/2
+	for i, line := range lines {
+		text.draw(canvas, line, left, baseline+i*lineHeight, color.White)
+	}
+	// 库名下方的短装饰线
+	barTop := baseline + lineHeight*(len(lines)-1) + int(size/2)
+	draw.Draw(canvas, image.Rect(left, barTop, left+int(size), barTop+4), image.NewUniform(color.NRGBA{255, 255, 255, 200}), image.Point{}, draw.Over)
+	return canvas, nil
+}
+
+// 解码海报，支持 JPEG、PNG 和 WebP
+func decodePoster(r io.Reader) (image.Image, error) {
+	img, _, err := image.Decode(r)
+	return img, err
+}
+
+// 先写到同目录的临时文件再改名，并发请求或中途出错时不会留下写了一半的封面
+func writeCover(fileName string, cover image.Image) error {
+	f, err := os.CreateTemp(filepath.Dir(fileName), filepath.Base(fileName)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if err := png.Encode(f, cover); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), fileName)
+}
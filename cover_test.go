@@ -0,0 +1,120 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/image/draw"
+	"golang.org/x/image/math/fixed"
+)
+
+// 纯色的假海报
+func testPosters(colors ...color.Color) []image.Image {
+	var posters []image.Image
+	for _, c := range colors {
+		img := image.NewRGBA(image.Rect(0, 0, 200, 300))
+		draw.Draw(img, img.Bounds(), image.NewUniform(c), image.Point{}, draw.Src)
+		posters = append(posters, img)
+	}
+	return posters
+}
+
+func TestRenderCover(t *testing.T) {
+	posters := testPosters(color.RGBA{200, 0, 0, 255}, color.RGBA{0, 0, 200, 255})
+	cover, err := renderCover("Movies", posters, loadCoverFonts(""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := cover.Bounds(); got.Dx() != coverWidth || got.Dy() != coverHeight {
+		t.Fatalf("cover size = %v, want %dx%d", got, coverWidth, coverHeight)
+	}
+	// 左上角是压暗的平均色背景
+	bg := coverBackground(posters)
+	if r, g, b, _ := cover.At(0, 0).RGBA(); uint8(r>>8) != bg.R || uint8(g>>8) != bg.G || uint8(b>>8) != bg.B {
+		t.Errorf("background = %v, want %v", cover.At(0, 0), bg)
+	}
+	// 右侧能看到海报墙
+	if r, _, b, _ := cover.At(coverWidth*72/100, coverHeight/2).RGBA(); r>>8 < 100 && b>>8 < 100 {
+		t.Errorf("poster wall not drawn, got %v", cover.At(coverWidth*72/100, coverHeight/2))
+	}
+	// 左侧画了白色的库名
+	white := false
+	for y := 0; y < coverHeight && !white; y++ {
+		for x := 0; x < coverWidth*45/100; x++ {
+			if r, g, b, _ := cover.At(x, y).RGBA(); r>>8 > 240 && g>>8 > 240 && b>>8 > 240 {
+				white = true
+				break
+			}
+		}
+	}
+	if !white {
+		t.Error("title not drawn")
+	}
+
+	if _, err := renderCover("Movies", nil, loadCoverFonts("")); err == nil {
+		t.Error("renderCover without posters should fail")
+	}
+}
+
+func TestCoverTextWrap(t *testing.T) {
+	text, err := newCoverText(loadCoverFonts(""), 40)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer text.Close()
+	maxWidth := text.measure("Science Fiction")
+	tests := []struct {
+		title string
+		want  []string
+	}{
+		{"Movies", []string{"Movies"}},
+		{"Science Fiction", []string{"Science Fiction"}},
+		{"Science Fiction Movies", []string{"Science Fiction", "Movies"}},
+		{"Kids Science Fiction", []string{"Kids Science", "Fiction"}},
+	}
+	for _, tt := range tests {
+		got := text.wrap(tt.title, maxWidth)
+		if strings.Join(got, "|") != strings.Join(tt.want, "|") {
+			t.Errorf("wrap(%q) = %q, want %q", tt.title, got, tt.want)
+		}
+	}
+
+	// 没有空格的长词逐字断开，每行都不超过最大宽度
+	word := strings.Repeat("W", 30)
+	lines := text.wrap(word, fixed.I(200))
+	if len(lines) < 2 || strings.Join(lines, "") != word {
+		t.Fatalf("wrap(%q) = %q", word, lines)
+	}
+	for _, line := range lines {
+		if text.measure(line) > fixed.I(200) {
+			t.Errorf("line %q is wider than 200px", line)
+		}
+	}
+}
+
+func TestWriteCover(t *testing.T) {
+	dir := t.TempDir()
+	fileName := filepath.Join(dir, "cover.png")
+	cover := testPosters(color.RGBA{0, 200, 0, 255})[0]
+	if err := writeCover(fileName, cover); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := png.Decode(f); err != nil {
+		t.Errorf("decode cover error: %v", err)
+	}
+	// 临时文件已经改名，目录中只剩封面
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 1 {
+		t.Errorf("files in dir = %d, want 1", len(entries))
+	}
+}
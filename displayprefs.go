@@ -0,0 +1,125 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"hash/fnv"
+	"io"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/dgraph-io/badger/v4"
+	log "github.com/sirupsen/logrus"
+)
+
+var displayPreferencesRe = regexp.MustCompile(`/DisplayPreferences/([^/]+)$`)
+
+// 每个虚拟库独立的 DisplayPreferencesId，由虚拟库 id 派生，改名时通过固定 id 保持不变
+func (l *Library) DisplayPreferencesID() string {
+	h := fnv.New128a()
+	h.Write([]byte("DisplayPreferences/" + l.VirtualID()))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func displayPreferencesIdIsVirtual(req *http.Request, libs map[string]Library) bool {
+	_, ok := libraryByDisplayPreferencesID(displayPreferencesId(req))
+	return ok
+}
+
+func displayPreferencesId(req *http.Request) string {
+	m := displayPreferencesRe.FindStringSubmatch(req.URL.Path)
+	if m == nil {
+		return ""
+	}
+	return normalizeGUID(m[1])
+}
+
+func libraryByDisplayPreferencesID(id string) (Library, bool) {
+	if id == "" {
+		return Library{}, false
+	}
+	cfg, _ := currentConfig()
+	for _, lib := range cfg.Library {
+		if lib.DisplayPreferencesID() == id {
+			return lib, true
+		}
+	}
+	return Library{}, false
+}
+
+// badger 中的 key：displayprefs/{prefsId}/{userId}/{client}
+func displayPreferencesKey(id string, req *http.Request) []byte {
+	client := queryGet(req.URL.Query(), "Client")
+	return []byte(strings.Join([]string{"displayprefs", id, getUserId(req), client}, "/"))
+}
+
+// 客户端第一次打开虚拟库时的默认显示设置
+func defaultDisplayPreferences(id, client string) map[string]interface{} {
+	return map[string]interface{}{
+		"Id":                 id,
+		"SortBy":             "SortName",
+		"SortOrder":          "Ascending",
+		"RememberIndexing":   false,
+		"RememberSorting":    false,
+		"PrimaryImageHeight": 250,
+		"PrimaryImageWidth":  250,
+		"ScrollDirection":    "Horizontal",
+		"ShowBackdrop":       true,
+		"ShowSidebar":        false,
+		"Client":             client,
+		"CustomPrefs":        map[string]interface{}{},
+	}
+}
+
+// 虚拟库的显示设置（视图、排序、分组）按用户和客户端保存在 badger 中
+func hookDisplayPreferences(resp *http.Response) error {
+	req := resp.Request
+	id := displayPreferencesId(req)
+	lib, ok := libraryByDisplayPreferencesID(id)
+	if !ok {
+		return nil
+	}
+	key := displayPreferencesKey(id, req)
+	if req.Method == http.MethodPost {
+		body, err := io.ReadAll(req.Body)
+		if err != nil {
+			return err
+		}
+		if !json.Valid(body) {
+			resp.StatusCode = http.StatusBadRequest
+			resp.Status = "400 Bad Request"
+			resp.Header.Set("Content-Type", "text/plain")
+			return writeBody(resp, []byte("invalid display preferences"))
+		}
+		err = badgerDB.Update(func(txn *badger.Txn) error {
+			return txn.Set(key, body)
+		})
+		if err != nil {
+			return err
+		}
+		log.Debugf("display preferences of %s saved: %s", lib.Name, key)
+		resp.StatusCode = http.StatusNoContent
+		resp.Status = "204 No Content"
+		resp.Header.Del("Content-Type")
+		return writeBody(resp, nil)
+	}
+
+	var prefs interface{}
+	err := badgerDB.View(func(txn *badger.Txn) error {
+		item, err := txn.Get(key)
+		if err != nil {
+			return err
+		}
+		return item.Value(func(val []byte) error {
+			return json.Unmarshal(val, &prefs)
+		})
+	})
+	if errors.Is(err, badger.ErrKeyNotFound) {
+		prefs = defaultDisplayPreferences(id, queryGet(req.URL.Query(), "Client"))
+	} else if err != nil {
+		return err
+	}
+	return replaceJSON(resp, prefs)
+}
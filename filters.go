@@ -0,0 +1,221 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	itemFiltersRe  = regexp.MustCompile(`/Items/Filters2?$`)
+	itemPrefixesRe = regexp.MustCompile(`/Items/Prefixes$`)
+)
+
+// 筛选面板和字母跳转栏的数据变化不频繁，大库拉一次全部条目开销较大，缓存久一些
+var libraryFacetsCache = newTTLCache[*libraryFacets](5 * time.Minute)
+
+// 虚拟库条目的筛选项
+type libraryFacets struct {
+	Genres          []nameIdPair
+	Tags            []nameIdPair
+	OfficialRatings []string
+	Years           []int
+	Prefixes        []string
+}
+
+type nameIdPair struct {
+	Name string `json:"Name"`
+	Id   string `json:"Id"`
+}
+
+// 统计库中条目的类型、标签、分级、年份和名称首字母，按库、用户和转发的客户端参数缓存
+func getLibraryFacets(lib *Library, orignalReq *http.Request) *libraryFacets {
+	// getItems 会转发客户端的筛选参数（IsFavorite、GenreIds 等），统计的是筛选后的条目，分页参数不要
+	req := withoutClientParams(orignalReq, "StartIndex", "Limit")
+	cacheKey := strings.Join([]string{lib.VirtualID(), getUserId(req), req.URL.RawQuery}, "|")
+	if facets, ok := libraryFacetsCache.Load(cacheKey); ok {
+		return facets
+	}
+
+	extQuery := url.Values{}
+	extQuery.Set("Fields", "Genres,Tags,OfficialRating,ProductionYear,SortName")
+	extQuery.Set("EnableImages", "false")
+	extQuery.Set("EnableUserData", "false")
+	items, _ := getItems(*lib, req, extQuery)["Items"].([]interface{})
+
+	facets := buildLibraryFacets(items)
+	libraryFacetsCache.Store(cacheKey, facets)
+	log.Debugf("library facets %s items: %d", lib.Name, len(items))
+	return facets
+}
+
+func buildLibraryFacets(items []interface{}) *libraryFacets {
+	facets := &libraryFacets{OfficialRatings: []string{}, Years: []int{}, Prefixes: []string{}}
+	genres := map[string]nameIdPair{}
+	tags := map[string]nameIdPair{}
+	ratings := map[string]bool{}
+	years := map[int]bool{}
+	prefixes := map[string]bool{}
+	for _, raw := range items {
+		item, ok := raw.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		collectPairs(genres, item["GenreItems"], item["Genres"])
+		collectPairs(tags, item["TagItems"], item["Tags"])
+		if rating, _ := item["OfficialRating"].(string); rating != "" {
+			ratings[rating] = true
+		}
+		if year, ok := item["ProductionYear"].(float64); ok && year > 0 {
+			years[int(year)] = true
+		}
+		name, _ := item["SortName"].(string)
+		if name == "" {
+			name, _ = item["Name"].(string)
+		}
+		if prefix := namePrefix(name); prefix != "" {
+			prefixes[prefix] = true
+		}
+	}
+	facets.Genres = sortedPairs(genres)
+	facets.Tags = sortedPairs(tags)
+	for rating := range ratings {
+		facets.OfficialRatings = append(facets.OfficialRatings, rating)
+	}
+	sort.Strings(facets.OfficialRatings)
+	for year := range years {
+		facets.Years = append(facets.Years, year)
+	}
+	sort.Ints(facets.Years)
+	for prefix := range prefixes {
+		facets.Prefixes = append(facets.Prefixes, prefix)
+	}
+	sort.Strings(facets.Prefixes)
+	return facets
+}
+
+// 优先使用带 id 的 GenreItems / TagItems，旧版本只有名称列表时 id 为空
+func collectPairs(pairs map[string]nameIdPair, withIds interface{}, names interface{}) {
+	list, _ := withIds.([]interface{})
+	for _, raw := range list {
+		m, _ := raw.(map[string]interface{})
+		name, _ := m["Name"].(string)
+		if name == "" {
+			continue
+		}
+		id := toString(m["Id"])
+		pairs[strings.ToLower(name)] = nameIdPair{Name: name, Id: id}
+	}
+	nameList, _ := names.([]interface{})
+	for _, raw := range nameList {
+		name, _ := raw.(string)
+		if _, ok := pairs[strings.ToLower(name)]; name != "" && !ok {
+			pairs[strings.ToLower(name)] = nameIdPair{Name: name}
+		}
+	}
+}
+
+func sortedPairs(pairs map[string]nameIdPair) []nameIdPair {
+	list := make([]nameIdPair, 0, len(pairs))
+	for _, pair := range pairs {
+		list = append(list, pair)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return strings.ToLower(list[i].Name) < strings.ToLower(list[j].Name)
+	})
+	return list
+}
+
+func pairNames(pairs []nameIdPair) []string {
+	names := make([]string, 0, len(pairs))
+	for _, pair := range pairs {
+		names = append(names, pair.Name)
+	}
+	return names
+}
+
+// 字母跳转栏的分组，非字母开头的归到 #
+func namePrefix(name string) string {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return ""
+	}
+	r := []rune(name)[0]
+	if !unicode.IsLetter(r) {
+		return "#"
+	}
+	return string(unicode.ToUpper(r))
+}
+
+// 复制请求并去掉不应转发给 getItems 的客户端参数，不区分大小写
+func withoutClientParams(orignalReq *http.Request, keys ...string) *http.Request {
+	req := orignalReq.Clone(orignalReq.Context())
+	q := req.URL.Query()
+	for key := range q {
+		if slices.ContainsFunc(keys, func(k string) bool { return strings.EqualFold(k, key) }) {
+			q.Del(key)
+		}
+	}
+	req.URL.RawQuery = q.Encode()
+	return req
+}
+
+func libraryForFacets(resp *http.Response) (*Library, bool, error) {
+	parentId := queryGet(resp.Request.URL.Query(), "ParentId")
+	cfg, libs := currentConfig()
+	lib, ok := lookupLibrary(libs, parentId)
+	if !ok {
+		return nil, false, nil
+	}
+	if !libraryVisible(cfg, &lib, resp.Request) {
+		return nil, false, refuseResponse(resp)
+	}
+	return &lib, true, nil
+}
+
+// /Items/Filters 返回名称列表，/Items/Filters2 返回带 id 的类型和标签
+func hookItemFilters(resp *http.Response) error {
+	log.Debug("hookItemFilters")
+	lib, ok, err := libraryForFacets(resp)
+	if !ok {
+		return err
+	}
+	facets := getLibraryFacets(lib, resp.Request)
+	if strings.HasSuffix(resp.Request.URL.Path, "Filters2") {
+		var tags interface{} = facets.Tags
+		if !flavor.TagPairs {
+			tags = pairNames(facets.Tags)
+		}
+		return replaceJSON(resp, map[string]interface{}{
+			"Genres": facets.Genres,
+			"Tags":   tags,
+		})
+	}
+	return replaceJSON(resp, map[string]interface{}{
+		"Genres":          pairNames(facets.Genres),
+		"Tags":            pairNames(facets.Tags),
+		"OfficialRatings": facets.OfficialRatings,
+		"Years":           facets.Years,
+	})
+}
+
+// /Items/Prefixes 返回 [{"Name": "A"}, ...]
+func hookItemPrefixes(resp *http.Response) error {
+	log.Debug("hookItemPrefixes")
+	lib, ok, err := libraryForFacets(resp)
+	if !ok {
+		return err
+	}
+	prefixes := make([]map[string]string, 0)
+	for _, prefix := range getLibraryFacets(lib, resp.Request).Prefixes {
+		prefixes = append(prefixes, map[string]string{"Name": prefix})
+	}
+	return replaceJSON(resp, prefixes)
+}
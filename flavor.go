@@ -0,0 +1,168 @@
+package main
+
+import (
+	"encoding/hex"
+	"hash/fnv"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+const (
+	serverTypeEmby     = "emby"
+	serverTypeJellyfin = "jellyfin"
+)
+
+// 服务端风格：Emby 和 Jellyfin 的 URL、条目 id 格式和认证方式不同
+type serverFlavor struct {
+	Name string
+	// 代理内部请求 API 时的路径前缀
+	APIPrefix string
+	// 以用户身份访问的接口，新路径通过 userId 参数指定用户
+	UserRoutes map[string]string
+	// 除 X-Emby-* 之外需要转发的认证参数，请求头和查询参数都会查找
+	AuthKeys []string
+	// 客户端查询参数是否需要转成首字母大写，Jellyfin 的客户端使用 camelCase
+	CanonicalQuery bool
+	// 配置中 id 的格式，用于错误提示
+	IDFormat string
+	// /Items/Filters2 中的 Tags 是否带 id，Jellyfin 只有名称
+	TagPairs bool
+
+	ViewsRe       *regexp.Regexp
+	LatestRe      *regexp.Regexp
+	ItemsRe       *regexp.Regexp
+	DetailIntroRe *regexp.Regexp
+	ImageRe       *regexp.Regexp
+	ResumeRe      *regexp.Regexp
+
+	// 库名 -> 虚拟库 id
+	HashID func(name string) string
+	// 配置中的 id 是否符合服务端的 id 格式
+	ValidID func(id string) bool
+	// 请求中的 id 转成虚拟库映射使用的形式
+	NormalizeID func(id string) string
+	// 对注入的库条目做服务端相关的调整
+	AdaptView func(item map[string]interface{})
+}
+
+var serverFlavors = map[string]*serverFlavor{
+	serverTypeEmby: {
+		Name:      serverTypeEmby,
+		APIPrefix: "/emby",
+		IDFormat:  "numeric",
+		TagPairs:  true,
+		ViewsRe:   regexp.MustCompile(`(/Users/[^/]+/Views|/UserViews)$`),
+		LatestRe:  regexp.MustCompile(`/Users/[^/]+/Items/Latest$`),
+		// 较新的客户端搜索时使用 /Items?UserId=
+		ItemsRe:       regexp.MustCompile(`(/Users/[^/]+)?/Items$`),
+		DetailIntroRe: regexp.MustCompile(`/Users/[^/]+/Items/\d+$`),
+		ImageRe:       regexp.MustCompile(`/Items/(\d+)/Images/(P|p)rimary$`),
+		ResumeRe:      regexp.MustCompile(`/Users/[^/]+/Items/Resume$`),
+		HashID: func(name string) string {
+			h := fnv.New32a()
+			h.Write([]byte(name))
+			return strconv.FormatUint(uint64(h.Sum32()), 10)
+		},
+		ValidID: func(id string) bool {
+			_, err := strconv.ParseUint(id, 10, 64)
+			return err == nil
+		},
+		NormalizeID: func(id string) string { return id },
+		AdaptView:   func(item map[string]interface{}) {},
+	},
+	serverTypeJellyfin: {
+		Name:      serverTypeJellyfin,
+		APIPrefix: "",
+		UserRoutes: map[string]string{
+			"/Users/{userId}/Items":        "/Items",
+			"/Users/{userId}/Views":        "/UserViews",
+			"/Users/{userId}/Items/Resume": "/UserItems/Resume",
+		},
+		AuthKeys:       []string{"Authorization", "X-MediaBrowser-Token", "api_key", "ApiKey"},
+		CanonicalQuery: true,
+		IDFormat:       "a 32 digit hex GUID",
+		// 10.9 之前的 /Users/{userId}/... 和之后的 /UserViews、/Items?userId= 两种路径都要处理
+		ViewsRe:       regexp.MustCompile(`(/Users/[^/]+/Views|/UserViews)$`),
+		LatestRe:      regexp.MustCompile(`(/Users/[^/]+)?/Items/Latest$`),
+		ItemsRe:       regexp.MustCompile(`(/Users/[^/]+)?/Items$`),
+		DetailIntroRe: regexp.MustCompile(`(/Users/[^/]+)?/Items/[0-9a-fA-F-]{32,36}$`),
+		ImageRe:       regexp.MustCompile(`/Items/([0-9a-fA-F-]{32,36})/Images/(P|p)rimary$`),
+		ResumeRe:      regexp.MustCompile(`(/Users/[^/]+/Items|/UserItems)/Resume$`),
+		HashID: func(name string) string {
+			h := fnv.New128a()
+			h.Write([]byte(name))
+			return hex.EncodeToString(h.Sum(nil))
+		},
+		ValidID: func(id string) bool {
+			id = normalizeGUID(id)
+			_, err := hex.DecodeString(id)
+			return len(id) == 32 && err == nil
+		},
+		NormalizeID: normalizeGUID,
+		AdaptView: func(item map[string]interface{}) {
+			// Jellyfin 的库条目没有这些 Emby 字段，根目录 id 也不是 1
+			delete(item, "Guid")
+			delete(item, "PresentationUniqueKey")
+			delete(item, "ParentId")
+			item["LocationType"] = "FileSystem"
+		},
+	},
+}
+
+// 当前的服务端风格，启动时根据 server_type 确定
+var flavor = serverFlavors[serverTypeEmby]
+
+func selectServerFlavor(serverType string) {
+	if f, ok := serverFlavors[strings.ToLower(serverType)]; ok {
+		flavor = f
+	}
+	hooks = newHooks()
+}
+
+// Jellyfin 的 GUID 在 DTO 中不带 "-"，客户端请求时可能带
+func normalizeGUID(id string) string {
+	return strings.ToLower(strings.ReplaceAll(id, "-", ""))
+}
+
+// 按请求中的 id 查找虚拟库
+func lookupLibrary(libs map[string]Library, id string) (Library, bool) {
+	lib, ok := libs[flavor.NormalizeID(id)]
+	return lib, ok
+}
+
+// 不区分大小写读取查询参数，Jellyfin 客户端使用 parentId、userId 等 camelCase 参数
+func queryGet(query url.Values, key string) string {
+	if v := query.Get(key); v != "" {
+		return v
+	}
+	for k, v := range query {
+		if strings.EqualFold(k, key) && len(v) > 0 {
+			return v[0]
+		}
+	}
+	return ""
+}
+
+func canonicalParam(key string) string {
+	if !flavor.CanonicalQuery || key == "" {
+		return key
+	}
+	r := []rune(key)
+	r[0] = unicode.ToUpper(r[0])
+	return string(r)
+}
+
+// 以用户身份访问的接口地址，Jellyfin 10.9 起用 userId 参数代替路径中的 /Users/{userId}
+func userURL(path string, userId string, query url.Values) string {
+	if to, ok := flavor.UserRoutes[path]; ok {
+		if userId != "" {
+			// 客户端的 userId 参数转发时已转成 UserId
+			query.Set("UserId", userId)
+		}
+		return embyURL(to, "")
+	}
+	return embyURL(path, userId)
+}
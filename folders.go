@@ -0,0 +1,96 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"regexp"
+	"slices"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Kodi 插件、Infuse 和一些电视端通过这些接口列出媒体库
+var (
+	mediaFoldersRe           = regexp.MustCompile(`/Library/MediaFolders$`)
+	selectableMediaFoldersRe = regexp.MustCompile(`/Library/SelectableMediaFolders$`)
+	groupingOptionsRe        = regexp.MustCompile(`(/Users/[^/]+|/UserViews)/GroupingOptions$`)
+)
+
+// 当前用户可见的虚拟库，按配置顺序
+func visibleLibraries(cfg *Config, req *http.Request) []Library {
+	var libs []Library
+	for _, lib := range cfg.Library {
+		if libraryVisible(cfg, &lib, req) {
+			libs = append(libs, lib)
+		}
+	}
+	return libs
+}
+
+// 真实库 id -> CollectionType，用于对只有名称和 id 的库列表应用 hide 规则
+func realCollectionTypes(req *http.Request) map[string]string {
+	var data map[string]interface{}
+	if getUserId(req) != "" {
+		data = fetchUserJSON("/Users/{userId}/Views", url.Values{}, req)
+	} else {
+		data = fetchUserJSON("/Library/MediaFolders", url.Values{}, req)
+	}
+	types := map[string]string{}
+	items, _ := data["Items"].([]interface{})
+	for _, raw := range items {
+		m, _ := raw.(map[string]interface{})
+		collectionType, _ := m["CollectionType"].(string)
+		types[itemId(m)] = collectionType
+	}
+	return types
+}
+
+// 只有名称和 id 的库列表：在前面插入虚拟库，并按 hide 规则去掉真实库
+func rewriteFolderList(resp *http.Response, newEntry func(lib *Library) map[string]interface{}) error {
+	cfg, _ := currentConfig()
+	hide := hideListFor(cfg, resp.Request)
+	var types map[string]string
+	if len(hide) > 0 && !slices.Contains(hide, "all") {
+		types = realCollectionTypes(resp.Request)
+	}
+	return rewriteJSON(resp, func(body interface{}) (interface{}, error) {
+		list, ok := body.([]interface{})
+		if !ok {
+			return body, nil
+		}
+		result := make([]interface{}, 0, len(list)+len(cfg.Library))
+		for _, lib := range visibleLibraries(cfg, resp.Request) {
+			result = append(result, newEntry(&lib))
+		}
+		for _, raw := range list {
+			m, _ := raw.(map[string]interface{})
+			if len(hide) > 0 && shouldHideView(hide, map[string]interface{}{"CollectionType": types[itemId(m)]}) {
+				continue
+			}
+			result = append(result, raw)
+		}
+		log.Debugf("%s folders count %d", resp.Request.URL.Path, len(result))
+		return result, nil
+	})
+}
+
+// /Library/SelectableMediaFolders: [{"Name", "Id", "SubFolders"}]
+func hookSelectableMediaFolders(resp *http.Response) error {
+	return rewriteFolderList(resp, func(lib *Library) map[string]interface{} {
+		return map[string]interface{}{
+			"Name":       lib.Name,
+			"Id":         lib.VirtualID(),
+			"SubFolders": []interface{}{},
+		}
+	})
+}
+
+// /Users/{userId}/GroupingOptions: [{"Name", "Id"}]
+func hookGroupingOptions(resp *http.Response) error {
+	return rewriteFolderList(resp, func(lib *Library) map[string]interface{} {
+		return map[string]interface{}{
+			"Name": lib.Name,
+			"Id":   lib.VirtualID(),
+		}
+	})
+}
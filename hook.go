@@ -0,0 +1,332 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/andybalholm/brotli"
+	log "github.com/sirupsen/logrus"
+)
+
+// Hook 改写某类 Emby 接口。请求阶段可以直接应答，不再转发给 Emby；
+// 响应阶段改写 Emby（或前面请求阶段）返回的响应，匹配的 hook 按优先级依次执行
+type Hook interface {
+	Match(req *http.Request) bool
+	// 数值小的先执行
+	Priority() int
+	// 返回非 nil 的响应表示已直接应答
+	OnRequest(req *http.Request) (*http.Response, error)
+	OnResponse(resp *http.Response) error
+}
+
+// RouteHook 按路径、方法和查询参数匹配请求的 Hook
+type RouteHook struct {
+	Name    string
+	Pattern *regexp.Regexp
+	// 为空时只匹配 GET 和 HEAD
+	Methods []string
+	// 可选，对查询参数做进一步判断
+	Query func(query url.Values) bool
+	Order int
+	// 默认不执行，只对在客户端配置中启用它的客户端生效
+	OptIn bool
+	// 可选，返回 true 时在请求阶段用空的 200 响应调用 Response 直接应答
+	Intercept func(req *http.Request, libs map[string]Library) bool
+	Response  func(resp *http.Response) error
+}
+
+func (h *RouteHook) Match(req *http.Request) bool {
+	methods := h.Methods
+	if len(methods) == 0 {
+		methods = []string{http.MethodGet, http.MethodHead}
+	}
+	if !slices.Contains(methods, req.Method) {
+		return false
+	}
+	if !h.Pattern.MatchString(req.URL.Path) {
+		return false
+	}
+	return h.Query == nil || h.Query(req.URL.Query())
+}
+
+func (h *RouteHook) Priority() int {
+	return h.Order
+}
+
+func (h *RouteHook) OnRequest(req *http.Request) (*http.Response, error) {
+	if h.Intercept == nil {
+		return nil, nil
+	}
+	_, libs := currentConfig()
+	if !h.Intercept(req, libs) {
+		return nil, nil
+	}
+	resp := &http.Response{
+		Status:     "200 OK",
+		StatusCode: http.StatusOK,
+		Proto:      req.Proto,
+		ProtoMajor: req.ProtoMajor,
+		ProtoMinor: req.ProtoMinor,
+		Header:     http.Header{},
+		Body:       io.NopCloser(bytes.NewReader(nil)),
+		Request:    req,
+	}
+	resp.Header.Set("Content-Type", "application/json")
+	return resp, h.Response(resp)
+}
+
+func (h *RouteHook) OnResponse(resp *http.Response) error {
+	if h.Response == nil {
+		return nil
+	}
+	return h.Response(resp)
+}
+
+func (h *RouteHook) String() string {
+	return h.Name
+}
+
+// 部分客户端通过 Users/xxx/Items 获取库列表，两种服务端风格相同
+var userItemsRe = regexp.MustCompile(`/Users/[^/]+/Items$`)
+
+var nextUpRe = regexp.MustCompile(`/Shows/NextUp$`)
+
+var hooks = newHooks()
+
+// 按当前服务端风格的路径生成 hook 列表
+func newHooks() []Hook {
+	return sortHooks([]Hook{
+		&RouteHook{Name: "views", Pattern: flavor.ViewsRe, Response: hookViews},
+		&RouteHook{Name: "media-folders", Pattern: mediaFoldersRe, Response: hookViews},
+		&RouteHook{Name: "selectable-media-folders", Pattern: selectableMediaFoldersRe, Response: hookSelectableMediaFolders},
+		&RouteHook{Name: "grouping-options", Pattern: groupingOptionsRe, Response: hookGroupingOptions},
+		&RouteHook{Name: "latest", Pattern: flavor.LatestRe, Intercept: parentIdIsVirtual, Response: hookLatest},
+		&RouteHook{Name: "items", Pattern: flavor.ItemsRe, Intercept: parentIdIsVirtual, Response: hookDetails},
+		// 很多 API 也通过 Users/xxx/Items + *Id 参数获取数据，所以只处理没有 *Id 参数的请求，
+		// 且只对在客户端配置中启用的客户端生效
+		&RouteHook{Name: "items-as-views", Pattern: userItemsRe, Query: hasNoIdParam, OptIn: true, Response: hookViews},
+		&RouteHook{Name: "item", Pattern: flavor.DetailIntroRe, Intercept: itemIdIsVirtual, Response: hookDetailIntro},
+		&RouteHook{Name: "image", Pattern: flavor.ImageRe, Intercept: imageIdIsVirtual, Response: hookImage},
+		&RouteHook{Name: "resume", Pattern: flavor.ResumeRe, Intercept: parentIdIsVirtual, Response: hookResume},
+		&RouteHook{Name: "nextup", Pattern: nextUpRe, Intercept: parentIdIsVirtual, Response: hookNextUp},
+		&RouteHook{Name: "search-hints", Pattern: searchHintsRe, Intercept: parentIdIsVirtual, Response: hookSearchHints},
+		&RouteHook{Name: "filters", Pattern: itemFiltersRe, Intercept: parentIdIsVirtual, Response: hookItemFilters},
+		&RouteHook{Name: "prefixes", Pattern: itemPrefixesRe, Intercept: parentIdIsVirtual, Response: hookItemPrefixes},
+		&RouteHook{Name: "browse-by-name", Pattern: browseByNameRe, Intercept: parentIdIsVirtual, Response: hookBrowseByName},
+		&RouteHook{
+			Name:      "display-preferences",
+			Pattern:   displayPreferencesRe,
+			Methods:   []string{http.MethodGet, http.MethodHead, http.MethodPost},
+			Intercept: displayPreferencesIdIsVirtual,
+			Response:  hookDisplayPreferences,
+		},
+		&RouteHook{Name: "base-url", Pattern: anyPathRe, Methods: allMethods, Order: 100, Response: hookBaseURL},
+	})
+}
+
+func sortHooks(list []Hook) []Hook {
+	sort.SliceStable(list, func(i, j int) bool {
+		return list[i].Priority() < list[j].Priority()
+	})
+	return list
+}
+
+func matchHooks(req *http.Request) []Hook {
+	var matched []Hook
+	client := clientProfileFor(req)
+	for _, hook := range hooks {
+		if client.runs(hook) && hook.Match(req) {
+			matched = append(matched, hook)
+		}
+	}
+	return matched
+}
+
+func parentIdIsVirtual(req *http.Request, libs map[string]Library) bool {
+	_, ok := lookupLibrary(libs, queryGet(req.URL.Query(), "ParentId"))
+	return ok
+}
+
+// /Users/{userId}/Items/{id}，Jellyfin 也可能是 /Items/{id}
+func itemIdIsVirtual(req *http.Request, libs map[string]Library) bool {
+	_, ok := lookupLibrary(libs, req.URL.Path[strings.LastIndex(req.URL.Path, "/")+1:])
+	return ok
+}
+
+// /Items/{id}/Images/Primary
+func imageIdIsVirtual(req *http.Request, libs map[string]Library) bool {
+	_, ok := lookupLibrary(libs, imageItemId(req.URL.Path))
+	return ok
+}
+
+func imageItemId(path string) string {
+	m := flavor.ImageRe.FindStringSubmatch(path)
+	if m == nil {
+		return ""
+	}
+	return m[1]
+}
+
+func hasNoIdParam(query url.Values) bool {
+	for key := range query {
+		if strings.HasSuffix(key, "Id") {
+			return false
+		}
+	}
+	return true
+}
+
+func hookName(hook Hook) string {
+	if s, ok := hook.(fmt.Stringer); ok {
+		return s.String()
+	}
+	return fmt.Sprintf("%T", hook)
+}
+
+// 依次执行匹配的响应阶段 hook，后面的 hook 看到的是前面改写后的响应
+func runResponseHooks(resp *http.Response, matched []Hook) error {
+	for _, hook := range matched {
+		log.Debugf("hook %s matched %s", hookName(hook), resp.Request.URL.Path)
+		hookStart := time.Now()
+		if err := hook.OnResponse(resp); err != nil {
+			return err
+		}
+		log.Debugf("hook %s %s cost: %v", hookName(hook), resp.Request.URL.Path, time.Since(hookStart))
+	}
+	return nil
+}
+
+func modifyResponse(resp *http.Response) error {
+	return runResponseHooks(resp, matchHooks(resp.Request))
+}
+
+// 请求阶段：有 hook 直接应答时把响应写回客户端，不再经过反向代理
+func serveRequestHooks(w http.ResponseWriter, req *http.Request) bool {
+	matched := matchHooks(req)
+	for i, hook := range matched {
+		hookStart := time.Now()
+		resp, err := hook.OnRequest(req)
+		if err == nil && resp == nil {
+			continue
+		}
+		log.Debugf("hook %s answered %s", hookName(hook), req.URL.Path)
+		if err == nil {
+			// 应答的 hook 已处理过，不再执行它的响应阶段
+			err = runResponseHooks(resp, slices.Delete(slices.Clone(matched), i, i+1))
+		}
+		if err != nil {
+			log.Warn("request hook error ", err)
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return true
+		}
+		for k, v := range resp.Header {
+			w.Header()[k] = v
+		}
+		w.WriteHeader(resp.StatusCode)
+		if req.Method != http.MethodHead {
+			io.Copy(w, resp.Body)
+		}
+		resp.Body.Close()
+		log.Debugf("hook %s %s cost: %v", hookName(hook), req.URL.Path, time.Since(hookStart))
+		return true
+	}
+	return false
+}
+
+// 按 Content-Encoding 解压读取响应体
+func readBody(resp *http.Response) ([]byte, error) {
+	if resp.Body == nil {
+		return nil, nil
+	}
+	defer resp.Body.Close()
+	var reader io.Reader = resp.Body
+	switch resp.Header.Get("Content-Encoding") {
+	case "br":
+		reader = brotli.NewReader(resp.Body)
+	case "deflate":
+		// HTTP 的 deflate 是带 zlib 头的格式
+		zr, err := zlib.NewReader(resp.Body)
+		if err != nil {
+			return nil, err
+		}
+		defer zr.Close()
+		reader = zr
+	case "gzip":
+		gz, err := gzip.NewReader(resp.Body)
+		if err != nil {
+			return nil, err
+		}
+		defer gz.Close()
+		reader = gz
+	}
+	return io.ReadAll(reader)
+}
+
+// 按原有 Content-Encoding 压缩后替换响应体，并更新长度
+func writeBody(resp *http.Response, body []byte) error {
+	encoding := resp.Header.Get("Content-Encoding")
+	encodedBody, err := encodeBodyByContentEncoding(body, encoding)
+	if err != nil {
+		return err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(encodedBody))
+	resp.ContentLength = int64(len(encodedBody))
+	resp.Header.Set("Content-Length", strconv.Itoa(len(encodedBody)))
+	if encoding == "" {
+		resp.Header.Del("Content-Encoding")
+	}
+	return nil
+}
+
+// 用 v 替换整个响应，状态码改为 200
+func replaceJSON(resp *http.Response, v interface{}) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	if resp.Body != nil {
+		resp.Body.Close()
+	}
+	resp.Header.Set("Content-Type", "application/json")
+	resp.StatusCode = http.StatusOK
+	resp.Status = "200 OK"
+	return writeBody(resp, body)
+}
+
+// 解析 JSON 响应交给 fn 改写，响应不是 JSON 时原样返回
+func rewriteJSON(resp *http.Response, fn func(data interface{}) (interface{}, error)) error {
+	body, err := readBody(resp)
+	if err != nil {
+		return err
+	}
+	var data interface{}
+	if err := json.Unmarshal(body, &data); err != nil {
+		log.Warn("json.Unmarshal error ", err)
+		resp.Body = io.NopCloser(bytes.NewReader(body))
+		resp.ContentLength = int64(len(body))
+		resp.Header.Set("Content-Length", strconv.Itoa(len(body)))
+		resp.Header.Del("Content-Encoding")
+		return nil
+	}
+	data, err = fn(data)
+	if err != nil {
+		return err
+	}
+	body, err = json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	return writeBody(resp, body)
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"bytes"
+	"compress/zlib"
+	"io"
+	"net/http"
+	"testing"
+)
+
+// 各种 Content-Encoding 下 writeBody 压缩的内容都能被 readBody 读回
+func TestBodyRoundTrip(t *testing.T) {
+	body := []byte(`{"Items":[{"Name":"The Matrix"}]}`)
+	for _, encoding := range []string{"", "gzip", "deflate", "br"} {
+		resp := &http.Response{Header: http.Header{}}
+		if encoding != "" {
+			resp.Header.Set("Content-Encoding", encoding)
+		}
+		if err := writeBody(resp, body); err != nil {
+			t.Fatalf("%q: writeBody error: %v", encoding, err)
+		}
+		got, err := readBody(resp)
+		if err != nil {
+			t.Fatalf("%q: readBody error: %v", encoding, err)
+		}
+		if !bytes.Equal(got, body) {
+			t.Errorf("%q: got %s, want %s", encoding, got, body)
+		}
+	}
+}
+
+// Emby 返回的 deflate 是 zlib 格式
+func TestReadDeflateBody(t *testing.T) {
+	body := []byte(`{"Items":[]}`)
+	var buf bytes.Buffer
+	zw := zlib.NewWriter(&buf)
+	zw.Write(body)
+	zw.Close()
+	resp := &http.Response{
+		Header: http.Header{"Content-Encoding": {"deflate"}},
+		Body:   io.NopCloser(&buf),
+	}
+	got, err := readBody(resp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, body) {
+		t.Errorf("got %s, want %s", got, body)
+	}
+}
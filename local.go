@@ -0,0 +1,270 @@
+package main
+
+import (
+	"math/rand"
+	"net/url"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 拉取条目的方式，区分用户请求和 API Key 请求
+type itemsFetcher func(query url.Values) map[string]interface{}
+
+// 本地排序需要的字段，请求 Emby 时追加到 Fields
+var localSortFields = []string{"SortName", "DateCreated", "PremiereDate", "ProductionYear", "CriticRating", "OfficialRating", "DateLastContentAdded"}
+
+// 在代理内完成排序和分页的库
+func (l *Library) IsLocal() bool {
+	return l.IsComposite() || l.Rule != ""
+}
+
+// smart 库的候选条目由 query 决定，再按 rule 在本地过滤
+func (l *Library) IsSmart() bool {
+	return l.ResourceType == "smart"
+}
+
+// 库或其任一来源是否带有规则
+func (l *Library) hasRule() bool {
+	if l.Rule != "" {
+		return true
+	}
+	found := false
+	l.walkSources(func(src *Library, _ string) {
+		found = found || src.hasRule()
+	})
+	return found
+}
+
+// 库及其来源的规则需要额外请求的 Fields
+func (l *Library) ruleFieldParams() []string {
+	var params []string
+	if l.Rule != "" {
+		if rule, err := compileRule(l.Rule); err == nil {
+			params = append(params, rule.fieldParams()...)
+		}
+	}
+	l.walkSources(func(src *Library, _ string) {
+		params = append(params, src.ruleFieldParams()...)
+	})
+	return params
+}
+
+// 本地库排好序的全部条目的缓存，客户端翻页时不必每页都重新拉取
+var localItemsCache = newTTLCache[[]interface{}](30 * time.Second)
+
+// 拉取本地库的全部候选条目，在本地排序和分页，TotalRecordCount 为过滤后的真实总数
+// scope 区分不同用户的缓存，为空时不缓存
+func localItems(lib *Library, query url.Values, scope string, fetch itemsFetcher) map[string]interface{} {
+	query = cloneValues(query)
+	startIndex, limit := query.Get("StartIndex"), query.Get("Limit")
+	query.Del("StartIndex")
+	query.Del("Limit")
+	cacheable := scope != "" && !strings.Contains(query.Get("SortBy"), "Random")
+	cacheKey := lib.VirtualID() + "|" + scope + "|" + query.Encode()
+	if cacheable {
+		if items, ok := localItemsCache.Load(cacheKey); ok {
+			return pageItems(items, startIndex, limit)
+		}
+	}
+
+	sortBy, sortOrder := query.Get("SortBy"), query.Get("SortOrder")
+	query.Del("SortBy")
+	query.Del("SortOrder")
+	query.Set("Fields", appendFields(query.Get("Fields"), slices.Concat(localSortFields, lib.ruleFieldParams())...))
+	items := collectItems(lib, query, fetch)
+	sortItems(items, sortBy, sortOrder)
+
+	if cacheable {
+		localItemsCache.Store(cacheKey, items)
+	}
+	return pageItems(items, startIndex, limit)
+}
+
+// 按 StartIndex 和 Limit 分页
+func pageItems(items []interface{}, startIndex, limit string) map[string]interface{} {
+	if items == nil {
+		items = []interface{}{}
+	}
+	total := len(items)
+	start, _ := strconv.Atoi(startIndex)
+	start = max(0, min(start, total))
+	end := total
+	if n, err := strconv.Atoi(limit); err == nil && n >= 0 {
+		end = min(start+n, total)
+	}
+	return map[string]interface{}{
+		"Items":            items[start:end],
+		"TotalRecordCount": total,
+	}
+}
+
+// 按 Emby 的 SortBy / SortOrder 语义在本地排序，SortOrder 可以逐个对应 SortBy
+func sortItems(items []interface{}, sortBy, sortOrder string) {
+	if sortBy == "" {
+		return
+	}
+	keys := strings.Split(sortBy, ",")
+	orders := strings.Split(sortOrder, ",")
+	if slices.Contains(keys, "Random") {
+		rand.Shuffle(len(items), func(i, j int) { items[i], items[j] = items[j], items[i] })
+		return
+	}
+	sort.SliceStable(items, func(i, j int) bool {
+		a, _ := items[i].(map[string]interface{})
+		b, _ := items[j].(map[string]interface{})
+		for k, key := range keys {
+			c := compareValues(sortValue(a, key), sortValue(b, key))
+			if c == 0 {
+				continue
+			}
+			order := orders[min(k, len(orders)-1)]
+			if strings.EqualFold(order, "Descending") {
+				return c > 0
+			}
+			return c < 0
+		}
+		return false
+	})
+}
+
+// 取排序字段的值，部分排序键对应的字段名和排序键不同
+func sortValue(item map[string]interface{}, key string) interface{} {
+	switch key {
+	case "SortName":
+		if v, ok := item["SortName"].(string); ok && v != "" {
+			return strings.ToLower(v)
+		}
+		name, _ := item["Name"].(string)
+		return strings.ToLower(name)
+	case "Name":
+		name, _ := item["Name"].(string)
+		return strings.ToLower(name)
+	case "Runtime":
+		return item["RunTimeTicks"]
+	case "DateLastContentAdded":
+		if v, ok := item["DateLastContentAdded"]; ok {
+			return v
+		}
+		return item["DateCreated"]
+	case "DatePlayed":
+		userData, _ := item["UserData"].(map[string]interface{})
+		return userData["LastPlayedDate"]
+	case "PlayCount":
+		userData, _ := item["UserData"].(map[string]interface{})
+		return userData["PlayCount"]
+	default:
+		return item[key]
+	}
+}
+
+// 比较两个 JSON 值，缺失值排在最前
+func compareValues(a, b interface{}) int {
+	if a == nil || b == nil {
+		switch {
+		case a == nil && b == nil:
+			return 0
+		case a == nil:
+			return -1
+		default:
+			return 1
+		}
+	}
+	switch av := a.(type) {
+	case float64:
+		if bv, ok := b.(float64); ok {
+			switch {
+			case av < bv:
+				return -1
+			case av > bv:
+				return 1
+			}
+			return 0
+		}
+	case bool:
+		if bv, ok := b.(bool); ok {
+			switch {
+			case av == bv:
+				return 0
+			case !av:
+				return -1
+			}
+			return 1
+		}
+	}
+	// ISO 8601 时间字符串可以直接按字符串比较
+	return strings.Compare(strings.ToLower(toString(a)), strings.ToLower(toString(b)))
+}
+
+func toString(v interface{}) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(v)
+	default:
+		return ""
+	}
+}
+
+// 追加 Fields，已存在的字段不重复追加
+func appendFields(fields string, extra ...string) string {
+	var list []string
+	if fields != "" {
+		list = strings.Split(fields, ",")
+	}
+	for _, f := range extra {
+		if !slices.Contains(list, f) {
+			list = append(list, f)
+		}
+	}
+	return strings.Join(list, ",")
+}
+
+// 拆分逗号分隔的列表
+func splitList(s string) []string {
+	var list []string
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			list = append(list, v)
+		}
+	}
+	return list
+}
+
+func cloneValues(values url.Values) url.Values {
+	clone := url.Values{}
+	for k, v := range values {
+		clone[k] = slices.Clone(v)
+	}
+	return clone
+}
+
+func itemId(item interface{}) string {
+	m, _ := item.(map[string]interface{})
+	id, _ := m["Id"].(string)
+	return id
+}
+
+func itemIdSet(items []interface{}) map[string]bool {
+	ids := make(map[string]bool, len(items))
+	for _, item := range items {
+		ids[itemId(item)] = true
+	}
+	return ids
+}
+
+// 保留 id 满足条件的条目
+func filterItems(items []interface{}, keep func(id string) bool) []interface{} {
+	result := make([]interface{}, 0, len(items))
+	for _, item := range items {
+		if keep(itemId(item)) {
+			result = append(result, item)
+		}
+	}
+	return result
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// 本地库在代理内排序和分页，TotalRecordCount 是集合运算和规则过滤后的总数
+func TestLocalItemsPaging(t *testing.T) {
+	lib := Library{
+		Name:         "Local",
+		ResourceType: "composite",
+		Any:          []Library{{ResourceType: "tag", ResourceID: "t1"}, {ResourceType: "tag", ResourceID: "t3"}},
+		None:         []Library{{ResourceType: "tag", ResourceID: "t4"}},
+		Rule:         `Name != "e"`,
+	}
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{"SortBy=SortName", "a,b,d"},
+		{"SortBy=SortName&SortOrder=Descending", "d,b,a"},
+		{"SortBy=SortName&StartIndex=1&Limit=1", "b"},
+		{"SortBy=SortName&SortOrder=Descending&StartIndex=1&Limit=5", "b,a"},
+		{"SortBy=SortName&StartIndex=3", ""},
+		{"SortBy=SortName&Limit=0", ""},
+	}
+	for _, tt := range tests {
+		query, _ := url.ParseQuery(tt.query)
+		data := localItems(&lib, query, "", fakeTagFetch)
+		items, _ := data["Items"].([]interface{})
+		var ids []string
+		for _, item := range items {
+			ids = append(ids, itemId(item))
+		}
+		if got := strings.Join(ids, ","); got != tt.want {
+			t.Errorf("%q: got %q, want %q", tt.query, got, tt.want)
+		}
+		if total := data["TotalRecordCount"]; total != 3 {
+			t.Errorf("%q: TotalRecordCount = %v, want 3", tt.query, total)
+		}
+	}
+}
@@ -2,23 +2,21 @@ package main
 
 import (
 	"bytes"
-	"compress/flate"
 	"compress/gzip"
+	"compress/zlib"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"hash/fnv"
-	"io"
+	"image"
 	"math/rand"
 	"net"
 	"net/http"
 	"net/http/httputil"
 	"net/url"
 	"os"
-	"os/exec"
-	"regexp"
 	"slices"
-	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/andybalholm/brotli"
@@ -29,24 +27,99 @@ import (
 
 // ================== Config Struct ==================
 type Config struct {
-	EmbyServer string    `yaml:"emby_server"`
-	LogLevel   string    `yaml:"log_level"`
-	EmbyApiKey string    `yaml:"emby_api_key"`
-	Hide       []string  `yaml:"hide"`
-	Library    []Library `yaml:"library"`
+	EmbyServer string `yaml:"emby_server"`
+	// 反代部署在子路径下时的路径前缀，例如 /emby-proxy
+	BaseURL string `yaml:"base_url"`
+	// emby 或 jellyfin，决定接口路径、认证方式和虚拟库 id 的格式
+	ServerType string              `yaml:"server_type"`
+	LogLevel   string              `yaml:"log_level"`
+	EmbyApiKey string              `yaml:"emby_api_key"`
+	Hide       []string            `yaml:"hide"`
+	UserGroups map[string][]string `yaml:"user_groups"`
+	UserHide   []UserHide          `yaml:"user_hide"`
+	// 封面库名使用的字体，缺字时回退到系统中的中文字体和内置字体
+	CoverFont string `yaml:"cover_font"`
+	// 客户端的特殊处理，优先于内置的客户端配置
+	ClientProfiles []ClientProfile `yaml:"client_profiles"`
+	Library        []Library       `yaml:"library"`
+}
+
+// 按用户覆盖全局 hide，users 可以是用户 id、用户名或 @用户组
+type UserHide struct {
+	Users []string `yaml:"users"`
+	Hide  []string `yaml:"hide"`
 }
 
 type Library struct {
-	Name         string `yaml:"name"`
-	ResourceID   string `yaml:"resource_id"`
-	ResourceType string `yaml:"resource_type"`
-	Image        string `yaml:"image"`
+	Name string `yaml:"name"`
+	// 固定的虚拟库 id，不设置时为名称的哈希，改名前的 id 通过 aliases 保留
+	ID           string   `yaml:"id"`
+	Aliases      []string `yaml:"aliases"`
+	ResourceID   string   `yaml:"resource_id"`
+	ResourceType string   `yaml:"resource_type"`
+	Image        string   `yaml:"image"`
+	AllowUsers   []string `yaml:"allow_users"`
+	DenyUsers    []string `yaml:"deny_users"`
+	// composite 库的来源：all 取交集，any 取并集，none 排除
+	All  []Library `yaml:"all"`
+	Any  []Library `yaml:"any"`
+	None []Library `yaml:"none"`
+	// movies、tvshows、music、mixed、homevideos、books，不设置时根据库中的条目类型推断
+	CollectionType string `yaml:"collection_type"`
+	// 合并到 Emby /Items 查询中的过滤条件，query 类型的库只由它决定
+	Query ItemQuery `yaml:"query"`
+	// 在代理内对条目求值的规则，smart 类型的库必填，其他类型作为额外过滤
+	Rule string `yaml:"rule"`
+}
+
+// Emby /Items 的查询参数，YAML 中的列表按逗号拼接
+type ItemQuery map[string]string
+
+func (q *ItemQuery) UnmarshalYAML(value *yaml.Node) error {
+	var raw map[string]yaml.Node
+	if err := value.Decode(&raw); err != nil {
+		return err
+	}
+	*q = ItemQuery{}
+	for k, node := range raw {
+		if node.Kind == yaml.SequenceNode {
+			var list []string
+			if err := node.Decode(&list); err != nil {
+				return fmt.Errorf("query %s: %w", k, err)
+			}
+			(*q)[k] = strings.Join(list, ",")
+			continue
+		}
+		(*q)[k] = node.Value
+	}
+	return nil
 }
 
 func (l *Library) NeedRecursive() bool {
 	return l.ResourceType != "collection"
 }
 
+// query 类型的库完全由 Query 中的过滤条件定义
+func (l *Library) IsQuery() bool {
+	return l.ResourceType == "query"
+}
+
+// 为库设置查询参数，库自身的条件覆盖已有的同名参数，
+// 和客户端筛选冲突的参数由 withClientFilters 改为取交集
+func (l *Library) applySource(query url.Values) {
+	if l.GetParamKey() != "" && l.ResourceID != "" {
+		query.Set(l.GetParamKey(), l.ResourceID)
+	}
+	if l.NeedRecursive() {
+		query.Set("Recursive", "true")
+	} else {
+		query.Del("Recursive")
+	}
+	for k, v := range l.Query {
+		query.Set(k, v)
+	}
+}
+
 // 返回参数名
 func (l *Library) GetParamKey() string {
 	switch l.ResourceType {
@@ -65,30 +138,12 @@ func (l *Library) GetParamKey() string {
 	}
 }
 
-var config Config
-var libraryMap = map[string]Library{}
-
 var (
-	hookViewsRe       = regexp.MustCompile(`/Users/[^/]+/Views$`)
-	hookLatestRe      = regexp.MustCompile(`/Users/[^/]+/Items/Latest$`)
-	hookDetailsRe     = regexp.MustCompile(`/Users/[^/]+/Items$`)
-	hookDetailIntroRe = regexp.MustCompile(`/Users/[^/]+/Items/\d+$`)
-	hookImageRe       = regexp.MustCompile(`/Items/\d+/Images/(P|p)rimary$`)
+	configMu   sync.RWMutex
+	config     = &Config{}
+	libraryMap = map[string]Library{}
 )
 
-type ResponseHook struct {
-	Pattern *regexp.Regexp
-	Handler func(*http.Response) error
-}
-
-var responseHooks = []ResponseHook{
-	{hookViewsRe, hookViews},
-	{hookLatestRe, hookLatest},
-	{hookDetailsRe, hookDetails},
-	{hookDetailIntroRe, hookDetailIntro},
-	{hookImageRe, hookImage},
-}
-
 var badgerDB *badger.DB
 
 // ================== Utility Functions ==================
@@ -106,32 +161,185 @@ func LoadConfig(path string) (*Config, error) {
 	return &cfg, nil
 }
 
-func HashNameToID(name string) string {
-	h := fnv.New32a()
-	h.Write([]byte(name))
-	return strconv.FormatUint(uint64(h.Sum32()), 10)
+// 校验配置，热重载时校验失败则继续使用旧配置
+func validateConfig(cfg *Config) error {
+	return errors.Join(configErrors(cfg)...)
 }
 
-// 获取 userId
-func getUserId(req *http.Request) string {
-	path := req.URL.Path
-	parts := strings.Split(path, "/")
-	userId := ""
-	if parts[1] == "emby" {
-		if len(parts) > 3 {
-			userId = parts[3]
+// 返回配置中所有不依赖 Emby 服务器就能发现的问题
+func configErrors(cfg *Config) []error {
+	var errs []error
+	if cfg.EmbyServer == "" {
+		errs = append(errs, fmt.Errorf("emby_server is empty"))
+	} else if u, err := url.Parse(cfg.EmbyServer); err != nil || u.Scheme == "" || u.Host == "" {
+		errs = append(errs, fmt.Errorf("emby_server invalid: %s", cfg.EmbyServer))
+	}
+	if strings.Contains(cfg.BaseURL, "://") || strings.ContainsAny(cfg.BaseURL, "?#") {
+		errs = append(errs, fmt.Errorf("base_url must be a path like /emby-proxy: %s", cfg.BaseURL))
+	}
+	if cfg.CoverFont != "" {
+		if _, err := os.Stat(cfg.CoverFont); err != nil {
+			errs = append(errs, fmt.Errorf("cover_font: %w", err))
 		}
-	} else {
-		if len(parts) > 2 {
-			userId = parts[2]
+	}
+	if _, ok := serverFlavors[strings.ToLower(cfg.ServerType)]; cfg.ServerType != "" && !ok {
+		errs = append(errs, fmt.Errorf("server_type %q is not one of emby, jellyfin", cfg.ServerType))
+	}
+	names := map[string]bool{}
+	ids := map[string]string{}
+	for i, lib := range cfg.Library {
+		if lib.Name == "" {
+			errs = append(errs, fmt.Errorf("library[%d]: name is empty", i))
+			continue
+		}
+		if names[lib.Name] {
+			errs = append(errs, fmt.Errorf("library %q: duplicate name", lib.Name))
+			continue
+		}
+		names[lib.Name] = true
+		errs = append(errs, libraryErrors(&lib, fmt.Sprintf("library %q", lib.Name))...)
+		if lib.ID != "" && !flavor.ValidID(lib.ID) {
+			errs = append(errs, fmt.Errorf("library %q: id %q must be %s", lib.Name, lib.ID, flavor.IDFormat))
+		}
+		for _, id := range append([]string{lib.VirtualID()}, lib.AliasIDs()...) {
+			if other, ok := ids[id]; ok && other != lib.Name {
+				errs = append(errs, fmt.Errorf("library %q: id %s collides with library %q", lib.Name, id, other))
+			}
+			ids[id] = lib.Name
+		}
+		errs = append(errs, userRuleErrors(cfg, fmt.Sprintf("library %q", lib.Name), lib.AllowUsers, lib.DenyUsers)...)
+	}
+	for i, rule := range cfg.UserHide {
+		errs = append(errs, userRuleErrors(cfg, fmt.Sprintf("user_hide[%d]", i), rule.Users)...)
+	}
+	for i, profile := range cfg.ClientProfiles {
+		errs = append(errs, clientProfileErrors(&profile, fmt.Sprintf("client_profiles[%d]", i))...)
+	}
+	return errs
+}
+
+// 检查库的资源定义，composite 库递归检查每个来源
+func libraryErrors(lib *Library, where string) []error {
+	var errs []error
+	if lib.Rule != "" {
+		if _, err := compileRule(lib.Rule); err != nil {
+			errs = append(errs, fmt.Errorf("%s: rule: %w", where, err))
+		}
+	}
+	if _, ok := collectionProfiles[lib.CollectionType]; lib.CollectionType != "" && !ok {
+		errs = append(errs, fmt.Errorf("%s: unknown collection_type %q", where, lib.CollectionType))
+	}
+	switch {
+	case lib.IsComposite():
+		if len(lib.All) == 0 && len(lib.Any) == 0 {
+			errs = append(errs, fmt.Errorf("%s: composite library needs at least one source in all or any", where))
+		}
+		lib.walkSources(func(src *Library, path string) {
+			if src.ResourceType == "" || (src.ResourceID == "" && !src.IsComposite() && !src.IsQuery() && !src.IsSmart()) {
+				errs = append(errs, fmt.Errorf("%s.%s: resource_type and resource_id are required", where, path))
+				return
+			}
+			errs = append(errs, libraryErrors(src, where+"."+path)...)
+		})
+	case lib.IsQuery():
+		if len(lib.Query) == 0 {
+			errs = append(errs, fmt.Errorf("%s: query library needs a non-empty query", where))
+		}
+	case lib.IsSmart():
+		if lib.Rule == "" {
+			errs = append(errs, fmt.Errorf("%s: smart library needs a rule", where))
+		}
+	case lib.ResourceType != "" && lib.GetParamKey() == "":
+		errs = append(errs, fmt.Errorf("%s: unknown resource_type %q", where, lib.ResourceType))
+	}
+	return errs
+}
+
+// 检查用户规则中引用的用户组是否存在
+func userRuleErrors(cfg *Config, where string, lists ...[]string) []error {
+	var errs []error
+	for _, rules := range lists {
+		for _, rule := range rules {
+			if group, ok := strings.CutPrefix(rule, "@"); ok {
+				if _, ok := cfg.UserGroups[group]; !ok {
+					errs = append(errs, fmt.Errorf("%s: unknown user group %q", where, group))
+				}
+			}
 		}
 	}
-	return userId
+	return errs
 }
 
-// 拼接 Emby API URL
+// 返回当前生效的配置和虚拟库映射，两者总是来自同一次加载
+// 热重载时整体替换而不是原地修改，调用方拿到的快照可以放心读取
+func currentConfig() (*Config, map[string]Library) {
+	configMu.RLock()
+	defer configMu.RUnlock()
+	return config, libraryMap
+}
+
+// 生成新的虚拟库映射并整体替换当前配置
+func applyConfig(cfg *Config) {
+	libs := make(map[string]Library, len(cfg.Library))
+	for _, lib := range cfg.Library {
+		for _, id := range lib.AliasIDs() {
+			libs[id] = lib
+		}
+	}
+	for _, lib := range cfg.Library {
+		libs[lib.VirtualID()] = lib
+	}
+	configMu.Lock()
+	config = cfg
+	libraryMap = libs
+	configMu.Unlock()
+}
+
+// 设置日志级别
+func setLogLevel(level string) {
+	switch strings.ToLower(level) {
+	case "debug":
+		log.SetLevel(log.DebugLevel)
+	case "warn":
+		log.SetLevel(log.WarnLevel)
+	case "error":
+		log.SetLevel(log.ErrorLevel)
+	default:
+		log.SetLevel(log.InfoLevel)
+	}
+}
+
+// Emby 为数字 id，Jellyfin 为 GUID
+func HashNameToID(name string) string {
+	return flavor.HashID(name)
+}
+
+// 虚拟库对外的 id
+func (l *Library) VirtualID() string {
+	if l.ID != "" {
+		return flavor.NormalizeID(l.ID)
+	}
+	return HashNameToID(l.Name)
+}
+
+// 仍然可用的旧 id：设置了 id 时名称的哈希，以及改名前各名称的哈希
+func (l *Library) AliasIDs() []string {
+	var ids []string
+	if l.ID != "" && l.ID != HashNameToID(l.Name) {
+		ids = append(ids, HashNameToID(l.Name))
+	}
+	for _, alias := range l.Aliases {
+		if id := HashNameToID(alias); id != l.VirtualID() && !slices.Contains(ids, id) {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// 拼接 Emby API URL，path 不带 /emby 前缀，由服务端风格决定
 func embyURL(path string, userId string) string {
-	return config.EmbyServer + strings.Replace(path, "{userId}", userId, 1)
+	cfg, _ := currentConfig()
+	return strings.TrimRight(cfg.EmbyServer, "/") + flavor.APIPrefix + strings.Replace(path, "{userId}", userId, 1)
 }
 
 // 通用 GET 请求并解析 JSON
@@ -141,16 +349,31 @@ func doGetJSON(
 	headers http.Header,
 	cookies []*http.Cookie,
 ) (map[string]interface{}, error) {
+	var data map[string]interface{}
+	if err := getJSON(baseURL, query, headers, cookies, &data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// GET 请求并把 JSON 解析到 v，响应可以是对象或数组
+func getJSON(
+	baseURL string,
+	query url.Values,
+	headers http.Header,
+	cookies []*http.Cookie,
+	v interface{},
+) error {
 	client := &http.Client{}
 	req, err := http.NewRequest("GET", baseURL, nil)
 	if err != nil {
-		return nil, err
+		return err
 	}
 	if query != nil {
 		req.URL.RawQuery = query.Encode()
 	}
-	for k, v := range headers {
-		for _, vv := range v {
+	for k, vs := range headers {
+		for _, vv := range vs {
 			req.Header.Add(k, vv)
 		}
 	}
@@ -159,20 +382,19 @@ func doGetJSON(
 	}
 	resp, err := client.Do(req)
 	if err != nil {
-		return nil, err
+		return err
 	}
 	defer resp.Body.Close()
-	var data map[string]interface{}
-	if err := json.NewDecoder(resp.Body).Decode(&data); err != nil {
-		return nil, err
+	if resp.StatusCode >= http.StatusBadRequest {
+		return fmt.Errorf("GET %s: %s", req.URL.Path, resp.Status)
 	}
-	return data, nil
+	return json.NewDecoder(resp.Body).Decode(v)
 }
 
 // 优化 X-Emby 参数处理，优先 originalQuery，其次 header，最后 query
 func setXEmbyParams(query, originalQuery url.Values, headers http.Header, originalHeaders http.Header) {
 	xEmbyKeys := []string{"X-Emby-Client", "X-Emby-Device-Name", "X-Emby-Device-Id", "X-Emby-Client-Version", "X-Emby-Token", "X-Emby-Language", "X-Emby-Authorization"}
-	for _, key := range xEmbyKeys {
+	for _, key := range slices.Concat(xEmbyKeys, flavor.AuthKeys) {
 		val := originalQuery.Get(key)
 		if val != "" {
 			query.Set(key, val)
@@ -199,7 +421,7 @@ func getAllCollections(boxId string, orignalReq *http.Request) []map[string]inte
 
 	cookies := orignalReq.Cookies()
 
-	url := embyURL("/emby/Users/{userId}/Items", userId)
+	url := userURL("/Users/{userId}/Items", userId, query)
 	data, err := doGetJSON(url, query, headers, cookies)
 	if err != nil {
 		return nil
@@ -225,7 +447,7 @@ func getFirstBoxset(orignalReq *http.Request) map[string]interface{} {
 
 	cookies := orignalReq.Cookies()
 
-	url := embyURL("/emby/Users/{userId}/Views", userId)
+	url := userURL("/Users/{userId}/Views", userId, query)
 	data, err := doGetJSON(url, query, headers, cookies)
 	if err != nil {
 		return nil
@@ -267,18 +489,23 @@ func ensureCollectionExist(id string, orignalReq *http.Request) bool {
 
 func getCollectionDataWithApi(lib Library, apiKey string) map[string]interface{} {
 	query := url.Values{}
-	if lib.GetParamKey() != "" && lib.ResourceID != "" {
-		query.Set(lib.GetParamKey(), lib.ResourceID)
-	}
 	query.Set("ImageTypeLimit", "1")
-	if lib.NeedRecursive() {
-		query.Set("Recursive", "true")
-	}
 	query.Set("Fields", "BasicSyncInfo,CanDelete,CanDownload,PrimaryImageAspectRatio,ProductionYear,Status,EndDate")
 	query.Set("EnableTotalRecordCount", "true")
+	lib.applySource(query)
+	if lib.IsLocal() {
+		return localItems(&lib, query, "", func(q url.Values) map[string]interface{} {
+			return fetchApiItems(q, apiKey)
+		})
+	}
+	return fetchApiItems(query, apiKey)
+}
+
+// 用 API Key 查询 /Items
+func fetchApiItems(query url.Values, apiKey string) map[string]interface{} {
 	query.Set("API_KEY", apiKey)
 
-	url := fmt.Sprintf("%s/emby/Items", config.EmbyServer)
+	url := embyURL("/Items", "")
 	headers := http.Header{}
 	headers.Set("accept", "application/json")
 	data, err := doGetJSON(url, query, headers, nil)
@@ -293,36 +520,63 @@ func getItems(lib Library, orignalReq *http.Request, extQuery url.Values) map[st
 	orignalQuery := orignalReq.URL.Query()
 	query := url.Values{} // 避免污染原始 query
 
-	if lib.GetParamKey() != "" && lib.ResourceID != "" {
-		query.Set(lib.GetParamKey(), lib.ResourceID)
-	}
-	log.Debug("getItems query ", query)
 	log.Debug("getItems orignalReq header ", orignalReq.Header)
 	log.Debug("getItems orignalReq url ", orignalReq.URL)
 	log.Debug("getItems orignalReq url path ", orignalReq.URL.Path)
 	log.Debug("getItems orignalReq url query ", orignalReq.URL.Query())
 	log.Debug("getItems extQuery ", extQuery)
 
-	// 为了过滤掉非电影、电视剧、视频、游戏、音乐专辑、剧集的资源，比如合集、播放列表等，主要是以原生流派为数据源时会出现
-	query.Set("IncludeItemTypes", "Movie,Series,Video,Game,MusicAlbum,Episode")
-	query.Set("ImageTypeLimit", orignalQuery.Get("ImageTypeLimit"))
-	query.Set("Fields", orignalQuery.Get("Fields"))
-	query.Set("EnableTotalRecordCount", orignalQuery.Get("EnableTotalRecordCount"))
-	if orignalQuery.Get("Filters") != "" {
-		query.Set("Filters", orignalQuery.Get("Filters"))
+	// 转发策略：库类型的默认条目类型 < 客户端的筛选、排序、分页参数 < 调用方的 extQuery < 库自身的条件
+	query.Set("IncludeItemTypes", lib.collectionProfile().IncludeItemTypes)
+	forwardClientQuery(query, orignalQuery)
+	for k, v := range extQuery {
+		query.Set(k, v[0])
 	}
-	if lib.NeedRecursive() {
-		query.Set("Recursive", "true")
+	lib = lib.withClientFilters(query)
+	lib.applySource(query)
+	log.Debug("getItems query ", query)
+
+	if lib.IsLocal() {
+		// 本地库在代理内分页，同一用户翻页时复用候选条目
+		return localItems(&lib, query, getUserId(orignalReq), func(q url.Values) map[string]interface{} {
+			return fetchUserItems(q, orignalReq)
+		})
+	}
+
+	data := fetchUserItems(query, orignalReq)
+	if data == nil {
+		return nil
 	}
-	if extQuery != nil {
-		for k, v := range extQuery {
-			query.Set(k, v[0])
+	items, _ := data["Items"].([]interface{})
+	log.Debug("getCollectionData data count", len(items))
+	return data
+}
+
+// 不转发给 Emby 的客户端参数，ParentId 是虚拟库 id，X-Emby-* 由 setXEmbyParams 处理
+var clientQueryDenylist = []string{"ParentId"}
+
+// 把客户端的筛选参数（Years、Genres、NameStartsWith、SearchTerm、IsPlayed 等）合并到查询中
+// 空值不转发，Emby 会把空值当成 false 或 0
+func forwardClientQuery(query, orignalQuery url.Values) {
+	for key, values := range orignalQuery {
+		if len(values) == 0 || values[0] == "" {
+			continue
 		}
-	} else {
-		query.Set("SortBy", orignalQuery.Get("SortBy"))
-		query.Set("SortOrder", orignalQuery.Get("SortOrder"))
+		denied := slices.ContainsFunc(clientQueryDenylist, func(d string) bool { return strings.EqualFold(d, key) })
+		if denied || strings.HasPrefix(strings.ToLower(key), "x-emby-") {
+			continue
+		}
+		query.Set(canonicalParam(key), values[0])
 	}
+}
+
+// 以原始请求的用户身份查询 /Users/{userId}/Items
+func fetchUserItems(query url.Values, orignalReq *http.Request) map[string]interface{} {
+	return fetchUserJSON("/Users/{userId}/Items", query, orignalReq)
+}
 
+// 以原始请求的用户身份请求 path，转发认证信息
+func fetchUserJSON(path string, query url.Values, orignalReq *http.Request) map[string]interface{} {
 	headers := http.Header{}
 	setXEmbyParams(query, orignalReq.URL.Query(), headers, orignalReq.Header)
 	log.Debug("getItems query after setXEmbyParams ", query)
@@ -334,12 +588,11 @@ func getItems(lib Library, orignalReq *http.Request, extQuery url.Values) map[st
 	cookies := orignalReq.Cookies()
 
 	userId := getUserId(orignalReq)
-	url := embyURL("/emby/Users/{userId}/Items", userId)
+	url := userURL(path, userId, query)
 	data, err := doGetJSON(url, query, headers, cookies)
 	if err != nil {
 		return nil
 	}
-	log.Debug("getCollectionData data count", len(data["Items"].([]interface{})))
 	return data
 }
 
@@ -351,15 +604,15 @@ func hookImage(resp *http.Response) error {
 		tag = resp.Request.URL.Query().Get("Tag")
 	}
 	if tag == "" {
-		components := strings.Split(resp.Request.URL.Path, "/")
 		// http://192.168.33.120:8096/Items/2122802865/Images/Primary
-		tag = components[2]
+		tag = imageItemId(resp.Request.URL.Path)
 	}
 	log.Debug("hookImage tag ", tag)
 	if tag == "" {
 		return nil
 	}
-	lib, ok := libraryMap[tag]
+	_, libs := currentConfig()
+	lib, ok := lookupLibrary(libs, tag)
 	if !ok {
 		log.Warn("hookImage tag not found ", tag)
 		return nil
@@ -376,10 +629,10 @@ func hookImage(resp *http.Response) error {
 		resp.Header.Set("Cache-Control", "public, max-age=86400")
 	} else {
 		// image = []byte{}
-		path := fmt.Sprintf("images/%s.png", lib.Name)
+		path := dataPath(lib.Name + ".png")
 		// check if file exists
 		if _, err := os.Stat(path); os.IsNotExist(err) {
-			placeholder, err := os.ReadFile("assets/placeholder.png")
+			placeholder, err := os.ReadFile(assetPath("placeholder.png"))
 			if err != nil {
 				return err
 			}
@@ -392,24 +645,13 @@ func hookImage(resp *http.Response) error {
 			resp.Header.Set("Cache-Control", "public, max-age=86400")
 		}
 	}
-	contentType := http.DetectContentType(image)
-	encoding := resp.Header.Get("Content-Encoding")
-	encodedBody, err := encodeBodyByContentEncoding(image, encoding)
-	if err != nil {
-		return err
-	}
-	resp.Body = io.NopCloser(bytes.NewReader(encodedBody))
-	resp.ContentLength = int64(len(encodedBody))
-	resp.Header.Set("Content-Length", strconv.Itoa(len(encodedBody)))
-	resp.Header.Set("Content-Type", contentType)
-	if encoding == "" {
-		resp.Header.Del("Content-Encoding")
-	} else {
-		resp.Header.Set("Content-Encoding", encoding)
+	if resp.Body != nil {
+		resp.Body.Close()
 	}
+	resp.Header.Set("Content-Type", http.DetectContentType(image))
 	resp.StatusCode = 200
 	resp.Status = "200 OK"
-	return nil
+	return writeBody(resp, image)
 }
 
 func hookDetailIntro(resp *http.Response) error {
@@ -460,128 +702,84 @@ func hookDetailIntro(resp *http.Response) error {
 	// get id after Items/
 	components := strings.Split(resp.Request.URL.Path, "/")
 	id := components[len(components)-1]
-	lib, ok := libraryMap[id]
+	cfg, libs := currentConfig()
+	lib, ok := lookupLibrary(libs, id)
 	if !ok {
 		return nil
 	}
+	if !libraryVisible(cfg, &lib, resp.Request) {
+		return refuseResponse(resp)
+	}
 	log.Debug("hookDetailIntro id", id)
 	var data map[string]interface{}
 	err := json.Unmarshal([]byte(template), &data)
 	if err != nil {
 		return err
 	}
-	// 用库名和 hash id 替换
+	// 用库名和虚拟库 id 替换，通过旧 id 访问时返回新 id
+	profile := lib.collectionProfile()
+	data["CollectionType"] = lib.collectionType()
+	data["Subviews"] = profile.Subviews
+	data["PrimaryImageAspectRatio"] = profile.PrimaryImageAspectRatio
 	data["Name"] = lib.Name
-	data["Id"] = id
+	data["Id"] = lib.VirtualID()
+	data["DisplayPreferencesId"] = lib.DisplayPreferencesID()
 	data["ImageTags"] = map[string]string{
-		"Primary": id,
-	}
-	bodyBytes, err := json.Marshal(data)
-	if err != nil {
-		return err
+		"Primary": lib.VirtualID(),
 	}
-	encoding := resp.Header.Get("Content-Encoding")
-	encodedBody, err := encodeBodyByContentEncoding(bodyBytes, encoding)
-	if err != nil {
-		return err
-	}
-	resp.Body = io.NopCloser(bytes.NewReader(encodedBody))
-	resp.ContentLength = int64(len(encodedBody))
-	resp.Header.Set("Content-Type", "application/json")
-	resp.Header.Set("Content-Length", strconv.Itoa(len(encodedBody)))
-	if encoding == "" {
-		resp.Header.Del("Content-Encoding")
-	} else {
-		resp.Header.Set("Content-Encoding", encoding)
-	}
-	resp.StatusCode = 200
-	resp.Status = "200 OK"
-	return nil
+	flavor.AdaptView(data)
+	clientProfileFor(resp.Request).adaptView(data)
+	return replaceJSON(resp, data)
 }
 
 func hookDetails(resp *http.Response) error {
 	log.Debug("hookDetails")
-	parentId := resp.Request.URL.Query().Get("ParentId")
-	lib, ok := libraryMap[parentId]
+	parentId := queryGet(resp.Request.URL.Query(), "ParentId")
+	cfg, libs := currentConfig()
+	lib, ok := lookupLibrary(libs, parentId)
 	if !ok {
-		// 网易爆米花通过 Users/xxx/Items 获取数据，所以需要特殊处理
-		// 又因很多 API 都通过 Users/xxx/Items + *Id 参数获取数据，所以需要过滤掉这些 API 调用
-		// 遍历 query，如果 key 没有以 Id 结尾，则返回
-		hasId := false
-		for key := range resp.Request.URL.Query() {
-			if strings.HasSuffix(key, "Id") {
-				hasId = true
-				break
-			}
-		}
-		if !hasId {
-			return hookViews(resp)
-		}
 		return nil
 	}
-	bodyText := getItems(lib, resp.Request, nil)
-	bodyBytes, err := json.Marshal(bodyText)
-	if err != nil {
-		return err
-	}
-	encoding := resp.Header.Get("Content-Encoding")
-	encodedBody, err := encodeBodyByContentEncoding(bodyBytes, encoding)
-	if err != nil {
-		return err
+	if !libraryVisible(cfg, &lib, resp.Request) {
+		return refuseResponse(resp)
 	}
-	resp.Body = io.NopCloser(bytes.NewReader(encodedBody))
-	resp.ContentLength = int64(len(encodedBody))
-	resp.Header.Set("Content-Type", "application/json")
-	resp.Header.Set("Content-Length", strconv.Itoa(len(encodedBody)))
-	if encoding == "" {
-		resp.Header.Del("Content-Encoding")
-	} else {
-		resp.Header.Set("Content-Encoding", encoding)
+	bodyText := getItems(lib, resp.Request, nil)
+	if bodyText == nil {
+		bodyText = map[string]interface{}{"Items": []interface{}{}, "TotalRecordCount": 0}
 	}
-	return nil
+	return replaceJSON(resp, bodyText)
 }
 
 func hookLatest(resp *http.Response) error {
 	log.Debug("hookLatest")
 	start := time.Now()
-	parentId := resp.Request.URL.Query().Get("ParentId")
-	lib, ok := libraryMap[parentId]
+	parentId := queryGet(resp.Request.URL.Query(), "ParentId")
+	cfg, libs := currentConfig()
+	lib, ok := lookupLibrary(libs, parentId)
 	if !ok {
 		return nil
 	}
+	if !libraryVisible(cfg, &lib, resp.Request) {
+		return refuseResponse(resp)
+	}
 	query := url.Values{}
 	query.Set("SortBy", "DateLastContentAdded,SortName")
 	query.Set("SortOrder", "Descending")
-	query.Set("Limit", resp.Request.URL.Query().Get("Limit"))
+	query.Set("Limit", queryGet(resp.Request.URL.Query(), "Limit"))
 	query.Set("IsPlayed", "false")
 	if lib.NeedRecursive() {
 		query.Set("Recursive", "true")
 	}
 	log.Debug("before getCollectionData")
 	getDataStart := time.Now()
-	items := getItems(lib, resp.Request, query)["Items"].([]interface{})
-	log.Debugf("getCollectionData done, cost: %v, items: %d", time.Since(getDataStart), len(items))
-	marshalStart := time.Now()
-	bodyBytes, err := json.Marshal(items)
-	log.Debugf("json.Marshal done, cost: %v", time.Since(marshalStart))
-	if err != nil {
-		return err
-	}
-	encoding := resp.Header.Get("Content-Encoding")
-	encodedBody, err := encodeBodyByContentEncoding(bodyBytes, encoding)
-	if err != nil {
-		return err
-	}
-	resp.Body = io.NopCloser(bytes.NewReader(encodedBody))
-	resp.ContentLength = int64(len(encodedBody))
-	resp.Header.Set("Content-Length", strconv.Itoa(len(encodedBody)))
-	if encoding == "" {
-		resp.Header.Del("Content-Encoding")
-	} else {
-		resp.Header.Set("Content-Encoding", encoding)
+	items, ok := getItems(lib, resp.Request, query)["Items"].([]interface{})
+	if !ok {
+		items = []interface{}{}
 	}
+	log.Debugf("getCollectionData done, cost: %v, items: %d", time.Since(getDataStart), len(items))
+	err := replaceJSON(resp, items)
 	log.Debugf("hookLatest total cost: %v", time.Since(start))
-	return nil
+	return err
 }
 
 func hookViews(resp *http.Response) error {
@@ -621,130 +819,67 @@ func hookViews(resp *http.Response) error {
 		}
 	}`
 	log.Debug("hookViews")
-	var bodyBytes []byte
-	var err error
-	log.Debug("resp.Header.Get(Content-Encoding)", resp.Header.Get("Content-Encoding"))
-	if resp.Header.Get("Content-Encoding") == "br" {
-		br := brotli.NewReader(resp.Body)
-		bodyBytes, err = io.ReadAll(br)
-		resp.Body.Close()
-	} else if resp.Header.Get("Content-Encoding") == "deflate" {
-		df := flate.NewReader(resp.Body)
-		bodyBytes, err = io.ReadAll(df)
-		resp.Body.Close()
-	} else if resp.Header.Get("Content-Encoding") == "gzip" {
-		gz, err := gzip.NewReader(resp.Body)
-		if err != nil {
-			log.Warn("gzip.NewReader error", err)
+	return rewriteJSON(resp, func(body interface{}) (interface{}, error) {
+		data, ok := body.(map[string]interface{})
+		if !ok {
+			return body, nil
 		}
-		bodyBytes, err = io.ReadAll(gz)
-		if err != nil {
-			log.Warn("io.ReadAll error", err)
+		items, _ := data["Items"].([]interface{})
+		if len(items) == 0 {
+			return data, nil
 		}
-		resp.Body.Close()
-	} else {
-		bodyBytes, err = io.ReadAll(resp.Body)
-		resp.Body.Close()
-	}
-	if err != nil {
-		return err
-	}
-	var data map[string]interface{}
-	err = json.Unmarshal(bodyBytes, &data)
-	if err != nil {
-		log.Warn("json.Unmarshal error", err)
-		resp.Body = io.NopCloser(bytes.NewReader(bodyBytes))
-		return nil
-	}
-	items, ok := data["Items"].([]interface{})
-	if !ok {
-		items = []interface{}{}
-	}
-	if len(items) == 0 {
-		return nil
-	}
-	typedItems := make([]map[string]interface{}, 0)
-	for _, item := range items {
-		typedItems = append(typedItems, item.(map[string]interface{}))
-	}
-	serverId := typedItems[0]["ServerId"].(string)
-	log.Debug("Items count ", len(typedItems))
-	// 遍历 config.Library，生成 item
-	var newItems []map[string]interface{}
-	for _, lib := range config.Library {
-		var item map[string]interface{}
-		err := json.Unmarshal([]byte(template), &item)
-		if err != nil {
-			continue
+		typedItems := make([]map[string]interface{}, 0)
+		for _, item := range items {
+			if item, ok := item.(map[string]interface{}); ok {
+				typedItems = append(typedItems, item)
+			}
 		}
-		item["Name"] = lib.Name
-		item["SortName"] = lib.Name
-		item["ForcedSortName"] = lib.Name
-		item["Id"] = HashNameToID(lib.Name)
-		item["ImageTags"] = map[string]string{
-			"Primary": HashNameToID(lib.Name),
+		if len(typedItems) == 0 {
+			return data, nil
 		}
-		item["ServerId"] = serverId
-		newItems = append(newItems, item)
-	}
-	// 根据配置决定是否合并真实库
-	if len(config.Hide) == 0 {
-		// do nothing
-	} else {
-		if slices.Contains(config.Hide, "all") {
-			typedItems = []map[string]interface{}{}
-		} else {
-			// items = newItems // 只显示虚拟库
+		serverId, _ := typedItems[0]["ServerId"].(string)
+		log.Debug("Items count ", len(typedItems))
+		cfg, _ := currentConfig()
+		client := clientProfileFor(resp.Request)
+		// 遍历 cfg.Library，生成 item
+		var newItems []map[string]interface{}
+		for _, lib := range visibleLibraries(cfg, resp.Request) {
+			var item map[string]interface{}
+			err := json.Unmarshal([]byte(template), &item)
+			if err != nil {
+				continue
+			}
+			item["Name"] = lib.Name
+			item["SortName"] = lib.Name
+			item["ForcedSortName"] = lib.Name
+			item["Id"] = lib.VirtualID()
+			item["DisplayPreferencesId"] = lib.DisplayPreferencesID()
+			item["ImageTags"] = map[string]string{
+				"Primary": lib.VirtualID(),
+			}
+			item["CollectionType"] = lib.collectionType()
+			item["PrimaryImageAspectRatio"] = lib.collectionProfile().PrimaryImageAspectRatio
+			item["ServerId"] = serverId
+			flavor.AdaptView(item)
+			client.adaptView(item)
+			newItems = append(newItems, item)
+		}
+		// 根据配置决定是否合并真实库
+		hide := hideListFor(cfg, resp.Request)
+		if len(hide) > 0 {
 			oldItems := []map[string]interface{}{}
 			for _, item := range typedItems {
-				if len(config.Hide) > 0 {
-					if slices.Contains(config.Hide, item["CollectionType"].(string)) {
-						continue
-					} else {
-						oldItems = append(oldItems, item)
-					}
+				if !shouldHideView(hide, item) {
+					oldItems = append(oldItems, item)
 				}
 			}
 			typedItems = oldItems
 		}
-	}
-	typedItems = append(newItems, typedItems...) // 合并
-	log.Debug("new view items count ", len(typedItems))
-	data["Items"] = typedItems
-	newBody, err := json.Marshal(data)
-	if err != nil {
-		return err
-	}
-	encoding := resp.Header.Get("Content-Encoding")
-	encodedBody, err := encodeBodyByContentEncoding(newBody, encoding)
-	if err != nil {
-		return err
-	}
-	resp.Body = io.NopCloser(bytes.NewReader(encodedBody))
-	resp.ContentLength = int64(len(encodedBody))
-	resp.Header.Set("Content-Length", strconv.Itoa(len(encodedBody)))
-	if encoding == "" {
-		resp.Header.Del("Content-Encoding")
-	} else {
-		resp.Header.Set("Content-Encoding", encoding)
-	}
-
-	return nil
-}
-
-func modifyResponse(resp *http.Response) error {
-	for _, hook := range responseHooks {
-		if hook.Pattern.MatchString(resp.Request.URL.Path) {
-			log.Debug("matched", resp.Request.URL.Path)
-			log.Debug("hook", hook.Pattern.String())
-			log.Debug("hook start", resp.Request.URL.Path)
-			hookStart := time.Now()
-			err := hook.Handler(resp)
-			log.Debugf("hook %s cost: %v", resp.Request.URL.Path, time.Since(hookStart))
-			return err
-		}
-	}
-	return nil
+		typedItems = append(newItems, typedItems...) // 合并
+		log.Debug("new view items count ", len(typedItems))
+		data["Items"] = typedItems
+		return data, nil
+	})
 }
 
 func encodeBodyByContentEncoding(body []byte, encoding string) ([]byte, error) {
@@ -759,15 +894,12 @@ func encodeBodyByContentEncoding(body []byte, encoding string) ([]byte, error) {
 		gz.Close()
 		return buf.Bytes(), nil
 	case "deflate":
-		df, err := flate.NewWriter(&buf, flate.DefaultCompression)
+		zw := zlib.NewWriter(&buf)
+		_, err := zw.Write(body)
 		if err != nil {
 			return nil, err
 		}
-		_, err = df.Write(body)
-		if err != nil {
-			return nil, err
-		}
-		df.Close()
+		zw.Close()
 		return buf.Bytes(), nil
 	case "br":
 		br := brotli.NewWriter(&buf)
@@ -804,14 +936,15 @@ func getImage(lib *Library) error {
 	if err != nil {
 		return err
 	}
-	fileName := fmt.Sprintf("images/%s.png", lib.Name)
+	fileName := dataPath(lib.Name + ".png")
 	fileExist, err := os.Stat(fileName)
 	if alreadyGenerated && err == nil && fileExist.Size() > 0 {
 		return nil
 	}
 	log.Debug("cover gen start", lib.Name)
 
-	items := getCollectionDataWithApi(*lib, config.EmbyApiKey)["Items"].([]interface{})
+	cfg, _ := currentConfig()
+	items, _ := getCollectionDataWithApi(*lib, cfg.EmbyApiKey)["Items"].([]interface{})
 	itemCount := len(items)
 	if itemCount == 0 {
 		log.Debug("no available image", lib.Name)
@@ -827,7 +960,8 @@ func getImage(lib *Library) error {
 		selected = items[:9]
 	}
 
-	for i, itemRaw := range selected {
+	var posters []image.Image
+	for _, itemRaw := range selected {
 		item := itemRaw.(map[string]interface{})
 		imageTags, ok := item["ImageTags"].(map[string]interface{})
 		if !ok {
@@ -841,32 +975,31 @@ func getImage(lib *Library) error {
 		if !ok {
 			continue
 		}
-		imageUrl := fmt.Sprintf("%s/emby/Items/%s/Images/Primary?maxHeight=600&maxWidth=400&tag=%s&quality=90", config.EmbyServer, itemId, imageId)
-		image, err := http.Get(imageUrl)
-		if err != nil {
-			return err
-		}
-		imageBytes, err := io.ReadAll(image.Body)
-		image.Body.Close()
+		imageUrl := embyURL(fmt.Sprintf("/Items/%s/Images/Primary?maxHeight=600&maxWidth=400&tag=%s&quality=90", itemId, imageId), "")
+		resp, err := http.Get(imageUrl)
 		if err != nil {
 			return err
 		}
-		os.MkdirAll(fmt.Sprintf("images/%s", lib.Name), 0755)
-		err = os.WriteFile(fmt.Sprintf("images/%s/%d.jpg", lib.Name, i+1), imageBytes, 0644)
+		poster, err := decodePoster(resp.Body)
+		resp.Body.Close()
 		if err != nil {
-			return err
+			log.Warnf("decode poster of %s error %v", itemId, err)
+			continue
 		}
+		posters = append(posters, poster)
+	}
+	if len(posters) == 0 {
+		log.Debug("no available image", lib.Name)
+		return nil
 	}
-	// uv run python gen.py
-	// call cmd to gen image
-	cmd := exec.Command("uv", "run", "python", "cover_gen.py", lib.Name)
-	cmd.Dir = "."
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	err = cmd.Run()
+	cover, err := renderCover(lib.Name, posters, loadCoverFonts(cfg.CoverFont))
 	if err != nil {
 		return err
 	}
+	if err := writeCover(fileName, cover); err != nil {
+		return err
+	}
+	log.Debugf("cover of %s saved to %s", lib.Name, fileName)
 
 	badgerDB.Update(func(txn *badger.Txn) error {
 		return txn.Set([]byte(lib.Name), []byte("1"))
@@ -874,42 +1007,57 @@ func getImage(lib *Library) error {
 	return err
 }
 
+// 异步为每个库生成封面
+func generateImages(libs []Library) {
+	for _, lib := range libs {
+		go func(l Library) {
+			err := getImage(&l)
+			if err != nil {
+				log.Warn("getImage error", err)
+			}
+		}(lib)
+	}
+}
+
+// 清除封面生成记录，下次 getImage 时重新生成
+func forgetImage(lib *Library) error {
+	return badgerDB.Update(func(txn *badger.Txn) error {
+		return txn.Delete([]byte(lib.Name))
+	})
+}
+
 func main() {
-	cfg, err := LoadConfig("config.yaml")
-	if err != nil {
-		log.Warn("LoadConfig error", err)
-		return
+	if len(os.Args) > 1 && os.Args[1] == "validate" {
+		os.Exit(runValidate(os.Args[2:]))
 	}
-	config = *cfg
+	newFlagSet(os.Args[0], &opts).Parse(os.Args[1:])
 
-	// 设置日志级别
-	switch strings.ToLower(config.LogLevel) {
-	case "debug":
-		log.SetLevel(log.DebugLevel)
-	case "warn":
-		log.SetLevel(log.WarnLevel)
-	case "error":
-		log.SetLevel(log.ErrorLevel)
-	default:
-		log.SetLevel(log.InfoLevel)
+	cfg, err := readConfig(opts.ConfigPath)
+	if err == nil {
+		// server_type 决定虚拟库 id 的格式，校验配置前确定
+		selectServerFlavor(cfg.ServerType)
+		err = validateConfig(cfg)
 	}
+	if err != nil {
+		log.Warn("loadConfig error ", err)
+		return
+	}
+	setLogLevel(cfg.LogLevel)
 	log.SetFormatter(&log.TextFormatter{
 		FullTimestamp: true,
 	})
 
 	// 初始化 Badger
-	badgerDB, err = badger.Open(badger.DefaultOptions("images/badger_db").WithLogger(nil))
+	badgerDB, err = badger.Open(badger.DefaultOptions(dataPath("badger_db")).WithLogger(nil))
 	if err != nil {
 		log.Warn("badger open error", err)
 		return
 	}
 	defer badgerDB.Close()
 
-	for _, lib := range config.Library {
-		libraryMap[HashNameToID(lib.Name)] = lib
-	}
+	applyConfig(cfg)
 
-	target, err := url.Parse(config.EmbyServer)
+	target, err := url.Parse(cfg.EmbyServer)
 	if err != nil {
 		log.Warn("url.Parse error", err)
 		return
@@ -951,23 +1099,23 @@ func main() {
 	}
 
 	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		r = stripBaseURL(r)
+		if serveRequestHooks(w, r) {
+			return
+		}
 		proxy.ServeHTTP(w, r)
 	})
 
 	// 异步获取图片
-	for _, lib := range config.Library {
-		libCopy := lib // 防止闭包变量问题
-		go func(l Library) {
-			// 这里可以只传递 l 和必要的参数
-			// 比如 userId、token 等
-			// getImage 需要调整为接收这些参数
-			err := getImage(&l)
-			if err != nil {
-				log.Warn("getImage error", err)
-			}
-		}(libCopy)
-	}
+	generateImages(cfg.Library)
+	detectCollectionTypes(cfg.Library, cfg.EmbyApiKey)
+	go warnLibraryIDCollisions(cfg)
 
-	log.Info("emby-virtual-lib listen on :8000")
-	http.ListenAndServe(":8000", nil)
+	// 监听配置文件变化和 SIGHUP，热重载配置
+	go watchConfig(opts.ConfigPath)
+
+	log.Info("emby-virtual-lib listen on ", opts.Listen)
+	if err := http.ListenAndServe(opts.Listen, nil); err != nil {
+		log.Warn("ListenAndServe error ", err)
+	}
 }
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+)
+
+// 启动参数，命令行参数优先，其次环境变量，最后默认值
+type Options struct {
+	ConfigPath string
+	Listen     string
+	DataDir    string
+	AssetsDir  string
+}
+
+var opts = Options{
+	ConfigPath: "config.yaml",
+	Listen:     ":8000",
+	DataDir:    "images",
+	AssetsDir:  "assets",
+}
+
+// 环境变量覆盖配置文件中的同名配置，避免把 emby_api_key 等敏感信息写进 YAML
+var configEnvOverrides = []struct {
+	Env   string
+	Field func(*Config) *string
+}{
+	{"EMBY_SERVER", func(c *Config) *string { return &c.EmbyServer }},
+	{"EMBY_API_KEY", func(c *Config) *string { return &c.EmbyApiKey }},
+	{"SERVER_TYPE", func(c *Config) *string { return &c.ServerType }},
+	{"BASE_URL", func(c *Config) *string { return &c.BaseURL }},
+	{"COVER_FONT", func(c *Config) *string { return &c.CoverFont }},
+	{"LOG_LEVEL", func(c *Config) *string { return &c.LogLevel }},
+}
+
+func envOr(key, def string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return def
+}
+
+func newFlagSet(name string, o *Options) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	fs.StringVar(&o.ConfigPath, "config", envOr("CONFIG_PATH", o.ConfigPath), "config file path (env CONFIG_PATH)")
+	fs.StringVar(&o.Listen, "listen", envOr("LISTEN", o.Listen), "listen address (env LISTEN)")
+	fs.StringVar(&o.DataDir, "data-dir", envOr("DATA_DIR", o.DataDir), "directory for generated covers and badger db (env DATA_DIR)")
+	fs.StringVar(&o.AssetsDir, "assets-dir", envOr("ASSETS_DIR", o.AssetsDir), "directory of bundled assets such as placeholder.png (env ASSETS_DIR)")
+	return fs
+}
+
+func applyEnvOverrides(cfg *Config) {
+	for _, o := range configEnvOverrides {
+		if v := os.Getenv(o.Env); v != "" {
+			*o.Field(cfg) = v
+		}
+	}
+}
+
+// 读取配置文件并应用环境变量覆盖，配置文件不存在时完全由环境变量提供
+func readConfig(path string) (*Config, error) {
+	cfg, err := LoadConfig(path)
+	if os.IsNotExist(err) {
+		cfg, err = &Config{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	applyEnvOverrides(cfg)
+	return cfg, nil
+}
+
+// 读取配置并应用环境变量覆盖后校验，热重载使用
+func loadValidConfig(path string) (*Config, error) {
+	cfg, err := readConfig(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := validateConfig(cfg); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// 数据目录下的路径
+func dataPath(elem ...string) string {
+	return filepath.Join(append([]string{opts.DataDir}, elem...)...)
+}
+
+// 资源目录下的路径
+func assetPath(elem ...string) string {
+	return filepath.Join(append([]string{opts.AssetsDir}, elem...)...)
+}
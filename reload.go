@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"os/signal"
+	"reflect"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// 配置文件轮询间隔，用轮询而不是 inotify，docker 挂载单个文件时编辑器替换文件也能感知
+const configPollInterval = 2 * time.Second
+
+var reloadMu sync.Mutex
+
+// 重新加载配置，校验失败时保留旧配置
+func reloadConfig(path string) {
+	reloadMu.Lock()
+	defer reloadMu.Unlock()
+
+	cfg, err := loadValidConfig(path)
+	if err != nil {
+		log.Warn("reload config failed, keep old config: ", err)
+		return
+	}
+	old, _ := currentConfig()
+	if cfg.EmbyServer != old.EmbyServer {
+		// 反向代理的目标地址在启动时确定
+		log.Warnf("emby_server changed from %s to %s, restart required to take effect", old.EmbyServer, cfg.EmbyServer)
+		cfg.EmbyServer = old.EmbyServer
+	}
+	if !strings.EqualFold(cfg.ServerType, old.ServerType) {
+		log.Warnf("server_type changed from %q to %q, restart required to take effect", old.ServerType, cfg.ServerType)
+		cfg.ServerType = old.ServerType
+	}
+	setLogLevel(cfg.LogLevel)
+
+	oldLibs := map[string]Library{}
+	for _, lib := range old.Library {
+		oldLibs[lib.Name] = lib
+	}
+	var changed []Library
+	for _, lib := range cfg.Library {
+		oldLib, ok := oldLibs[lib.Name]
+		if ok && reflect.DeepEqual(oldLib, lib) {
+			continue
+		}
+		if ok {
+			// 库定义变了，旧封面作废
+			if err := forgetImage(&lib); err != nil {
+				log.Warn("forgetImage error ", err)
+			}
+		}
+		changed = append(changed, lib)
+	}
+
+	applyConfig(cfg)
+	// 库定义可能变了，条目和筛选项按新定义重新统计
+	localItemsCache.Clear()
+	libraryFacetsCache.Clear()
+	browseByNameCache.Clear()
+	log.Infof("config reloaded, %d libraries, %d added or changed", len(cfg.Library), len(changed))
+	generateImages(changed)
+	detectCollectionTypes(changed, cfg.EmbyApiKey)
+	go warnLibraryIDCollisions(cfg)
+}
+
+// 监听配置文件内容变化和 SIGHUP
+func watchConfig(path string) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+
+	last, _ := os.ReadFile(path)
+	ticker := time.NewTicker(configPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-hup:
+			log.Info("SIGHUP received, reloading config")
+			last, _ = os.ReadFile(path)
+			reloadConfig(path)
+		case <-ticker.C:
+			content, err := os.ReadFile(path)
+			if err != nil || bytes.Equal(content, last) {
+				continue
+			}
+			last = content
+			log.Info("config file changed, reloading config")
+			reloadConfig(path)
+		}
+	}
+}
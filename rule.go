@@ -0,0 +1,503 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// 智能库的规则表达式，在代理内对 Emby 返回的条目 JSON 求值
+//
+//	expr    := and (('||' | 'or') and)*
+//	and     := unary (('&&' | 'and') unary)*
+//	unary   := ('!' | 'not') unary | cmp
+//	cmp     := operand (op operand)?
+//	op      := '==' | '!=' | '<' | '<=' | '>' | '>=' | '=~' | '!~' | 'in' | 'contains'
+//	operand := number | string | 'true' | 'false' | 'null' | field | list | '(' expr ')'
+//	field   := ident ('.' ident)*
+//	list    := '[' operand (',' operand)* ']'
+//
+// 例如 Runtime < 100 && CommunityRating > 7.5 && !UserData.Played && Name =~ "^The "
+type compiledRule struct {
+	eval   func(item map[string]interface{}) interface{}
+	fields []string // 规则引用的顶层字段，用于补充请求的 Fields
+}
+
+func (r *compiledRule) Match(item map[string]interface{}) bool {
+	return truthy(r.eval(item))
+}
+
+// 计算字段，不在 Emby 返回的 JSON 中
+var ruleVirtualFields = map[string]func(item map[string]interface{}) interface{}{
+	// 时长，单位分钟
+	"Runtime": func(item map[string]interface{}) interface{} {
+		ticks, ok := item["RunTimeTicks"].(float64)
+		if !ok {
+			return nil
+		}
+		return ticks / 600000000
+	},
+}
+
+// 条目 JSON 字段对应的 Emby Fields 参数，默认返回的字段不需要
+var ruleFieldParamNames = map[string]string{
+	"Runtime":              "",
+	"DateCreated":          "DateCreated",
+	"DateLastContentAdded": "DateLastContentAdded",
+	"Genres":               "Genres",
+	"GenreItems":           "Genres",
+	"Overview":             "Overview",
+	"OriginalTitle":        "OriginalTitle",
+	"Path":                 "Path",
+	"People":               "People",
+	"PremiereDate":         "PremiereDate",
+	"ProductionYear":       "ProductionYear",
+	"ProviderIds":          "ProviderIds",
+	"SortName":             "SortName",
+	"Studios":              "Studios",
+	"Tags":                 "Tags",
+	"TagItems":             "Tags",
+	"Taglines":             "Taglines",
+	"OfficialRating":       "OfficialRating",
+	"CriticRating":         "CriticRating",
+	"MediaStreams":         "MediaStreams",
+	"Width":                "Width",
+	"Height":               "Height",
+}
+
+// 规则需要额外请求的 Fields
+func (r *compiledRule) fieldParams() []string {
+	var params []string
+	for _, f := range r.fields {
+		if p := ruleFieldParamNames[f]; p != "" && !slices.Contains(params, p) {
+			params = append(params, p)
+		}
+	}
+	return params
+}
+
+var ruleCache sync.Map
+
+// 编译规则，相同的规则只编译一次
+func compileRule(src string) (*compiledRule, error) {
+	if r, ok := ruleCache.Load(src); ok {
+		return r.(*compiledRule), nil
+	}
+	tokens, err := tokenizeRule(src)
+	if err != nil {
+		return nil, err
+	}
+	p := &ruleParser{tokens: tokens}
+	eval, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != tokEOF {
+		return nil, fmt.Errorf("unexpected %q at %d", t.text, t.pos)
+	}
+	r := &compiledRule{eval: eval, fields: p.fields}
+	ruleCache.Store(src, r)
+	return r, nil
+}
+
+// ================== Tokenizer ==================
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokNum
+	tokStr
+	tokIdent
+	tokOp
+)
+
+type ruleToken struct {
+	kind tokenKind
+	text string
+	num  float64
+	pos  int
+}
+
+var ruleOps = []string{"&&", "||", "==", "!=", "<=", ">=", "=~", "!~", "<", ">", "!", "(", ")", "[", "]", ","}
+
+func tokenizeRule(src string) ([]ruleToken, error) {
+	var tokens []ruleToken
+	i := 0
+	for i < len(src) {
+		c := rune(src[i])
+		switch {
+		case unicode.IsSpace(c):
+			i++
+		case c == '"' || c == '\'':
+			var sb strings.Builder
+			j := i + 1
+			for ; j < len(src) && rune(src[j]) != c; j++ {
+				if src[j] == '\\' && j+1 < len(src) {
+					j++
+				}
+				sb.WriteByte(src[j])
+			}
+			if j >= len(src) {
+				return nil, fmt.Errorf("unterminated string at %d", i)
+			}
+			tokens = append(tokens, ruleToken{kind: tokStr, text: sb.String(), pos: i})
+			i = j + 1
+		case unicode.IsDigit(c) || (c == '-' && i+1 < len(src) && unicode.IsDigit(rune(src[i+1]))):
+			j := i + 1
+			for j < len(src) && (unicode.IsDigit(rune(src[j])) || src[j] == '.') {
+				j++
+			}
+			n, err := strconv.ParseFloat(src[i:j], 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid number %q at %d", src[i:j], i)
+			}
+			tokens = append(tokens, ruleToken{kind: tokNum, text: src[i:j], num: n, pos: i})
+			i = j
+		case isIdentChar(c) && !unicode.IsDigit(c):
+			j := i + 1
+			for j < len(src) && (isIdentChar(rune(src[j])) || src[j] == '.') {
+				j++
+			}
+			tokens = append(tokens, ruleToken{kind: tokIdent, text: src[i:j], pos: i})
+			i = j
+		default:
+			matched := false
+			for _, op := range ruleOps {
+				if strings.HasPrefix(src[i:], op) {
+					tokens = append(tokens, ruleToken{kind: tokOp, text: op, pos: i})
+					i += len(op)
+					matched = true
+					break
+				}
+			}
+			if !matched {
+				return nil, fmt.Errorf("unexpected character %q at %d", c, i)
+			}
+		}
+	}
+	return append(tokens, ruleToken{kind: tokEOF, pos: len(src)}), nil
+}
+
+// 字段名只允许 ASCII 字母、数字和下划线
+func isIdentChar(c rune) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+}
+
+// ================== Parser ==================
+type ruleEval = func(item map[string]interface{}) interface{}
+
+type ruleParser struct {
+	tokens []ruleToken
+	pos    int
+	fields []string
+}
+
+func (p *ruleParser) peek() ruleToken {
+	return p.tokens[p.pos]
+}
+
+func (p *ruleParser) next() ruleToken {
+	t := p.tokens[p.pos]
+	if t.kind != tokEOF {
+		p.pos++
+	}
+	return t
+}
+
+// 当前 token 是否为给定的运算符或关键字，是则消费
+func (p *ruleParser) accept(ops ...string) (string, bool) {
+	t := p.peek()
+	if t.kind != tokOp && t.kind != tokIdent {
+		return "", false
+	}
+	for _, op := range ops {
+		if t.text == op {
+			p.pos++
+			return op, true
+		}
+	}
+	return "", false
+}
+
+func (p *ruleParser) expect(op string) error {
+	if _, ok := p.accept(op); !ok {
+		t := p.peek()
+		return fmt.Errorf("expected %q at %d, got %q", op, t.pos, t.text)
+	}
+	return nil
+}
+
+func (p *ruleParser) parseOr() (ruleEval, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		if _, ok := p.accept("||", "or"); !ok {
+			return left, nil
+		}
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		l := left
+		left = func(item map[string]interface{}) interface{} {
+			return truthy(l(item)) || truthy(right(item))
+		}
+	}
+}
+
+func (p *ruleParser) parseAnd() (ruleEval, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		if _, ok := p.accept("&&", "and"); !ok {
+			return left, nil
+		}
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		l := left
+		left = func(item map[string]interface{}) interface{} {
+			return truthy(l(item)) && truthy(right(item))
+		}
+	}
+}
+
+func (p *ruleParser) parseUnary() (ruleEval, error) {
+	if _, ok := p.accept("!", "not"); ok {
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return func(item map[string]interface{}) interface{} {
+			return !truthy(operand(item))
+		}, nil
+	}
+	return p.parseCompare()
+}
+
+func (p *ruleParser) parseCompare() (ruleEval, error) {
+	left, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+	op, ok := p.accept("==", "!=", "<=", ">=", "<", ">", "=~", "!~", "in", "contains")
+	if !ok {
+		return left, nil
+	}
+	if op == "=~" || op == "!~" {
+		t := p.next()
+		if t.kind != tokStr {
+			return nil, fmt.Errorf("%s expects a string pattern at %d", op, t.pos)
+		}
+		re, err := regexp.Compile(t.text)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern at %d: %w", t.pos, err)
+		}
+		negate := op == "!~"
+		return func(item map[string]interface{}) interface{} {
+			matched := anyValue(left(item), func(v interface{}) bool {
+				s, ok := v.(string)
+				return ok && re.MatchString(s)
+			})
+			return matched != negate
+		}, nil
+	}
+	right, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+	return func(item map[string]interface{}) interface{} {
+		return applyRuleOp(op, left(item), right(item))
+	}, nil
+}
+
+func (p *ruleParser) parseOperand() (ruleEval, error) {
+	t := p.next()
+	switch t.kind {
+	case tokNum:
+		return constant(t.num), nil
+	case tokStr:
+		return constant(t.text), nil
+	case tokIdent:
+		switch t.text {
+		case "true":
+			return constant(true), nil
+		case "false":
+			return constant(false), nil
+		case "null":
+			return constant(nil), nil
+		}
+		return p.field(t.text), nil
+	case tokOp:
+		switch t.text {
+		case "(":
+			expr, err := p.parseOr()
+			if err != nil {
+				return nil, err
+			}
+			return expr, p.expect(")")
+		case "[":
+			var elems []ruleEval
+			if _, ok := p.accept("]"); ok {
+				return constant([]interface{}{}), nil
+			}
+			for {
+				elem, err := p.parseOperand()
+				if err != nil {
+					return nil, err
+				}
+				elems = append(elems, elem)
+				if _, ok := p.accept(","); ok {
+					continue
+				}
+				if err := p.expect("]"); err != nil {
+					return nil, err
+				}
+				break
+			}
+			return func(item map[string]interface{}) interface{} {
+				list := make([]interface{}, len(elems))
+				for i, elem := range elems {
+					list[i] = elem(item)
+				}
+				return list
+			}, nil
+		}
+	}
+	if t.kind == tokEOF {
+		return nil, fmt.Errorf("unexpected end of rule")
+	}
+	return nil, fmt.Errorf("unexpected %q at %d", t.text, t.pos)
+}
+
+func constant(v interface{}) ruleEval {
+	return func(map[string]interface{}) interface{} { return v }
+}
+
+// 字段路径，例如 UserData.Played
+func (p *ruleParser) field(path string) ruleEval {
+	parts := strings.Split(path, ".")
+	if !slices.Contains(p.fields, parts[0]) {
+		p.fields = append(p.fields, parts[0])
+	}
+	return func(item map[string]interface{}) interface{} {
+		var v interface{}
+		if f, ok := ruleVirtualFields[parts[0]]; ok && item[parts[0]] == nil {
+			v = f(item)
+		} else {
+			v = item[parts[0]]
+		}
+		for _, part := range parts[1:] {
+			m, ok := v.(map[string]interface{})
+			if !ok {
+				return nil
+			}
+			v = m[part]
+		}
+		return v
+	}
+}
+
+// ================== Evaluation ==================
+func truthy(v interface{}) bool {
+	switch v := v.(type) {
+	case nil:
+		return false
+	case bool:
+		return v
+	case float64:
+		return v != 0
+	case string:
+		return v != ""
+	case []interface{}:
+		return len(v) > 0
+	default:
+		return true
+	}
+}
+
+// 对数组的每个元素求值，有一个满足即可，非数组按单个值处理
+func anyValue(v interface{}, fn func(interface{}) bool) bool {
+	if list, ok := v.([]interface{}); ok {
+		return slices.ContainsFunc(list, func(e interface{}) bool { return fn(namedValue(e)) })
+	}
+	return fn(namedValue(v))
+}
+
+// GenreItems、Studios 等对象数组按 Name 比较
+func namedValue(v interface{}) interface{} {
+	if m, ok := v.(map[string]interface{}); ok {
+		if name, ok := m["Name"]; ok {
+			return name
+		}
+	}
+	return v
+}
+
+func equalValues(a, b interface{}) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	if as, ok := a.(string); ok {
+		if bs, ok := b.(string); ok {
+			return strings.EqualFold(as, bs)
+		}
+	}
+	return compareValues(a, b) == 0
+}
+
+func applyRuleOp(op string, left, right interface{}) bool {
+	switch op {
+	case "==":
+		if right == nil {
+			return left == nil
+		}
+		return anyValue(left, func(v interface{}) bool { return equalValues(v, right) })
+	case "!=":
+		if right == nil {
+			return left != nil
+		}
+		return !anyValue(left, func(v interface{}) bool { return equalValues(v, right) })
+	case "<", "<=", ">", ">=":
+		if left == nil || right == nil {
+			return false
+		}
+		c := compareValues(left, right)
+		switch op {
+		case "<":
+			return c < 0
+		case "<=":
+			return c <= 0
+		case ">":
+			return c > 0
+		default:
+			return c >= 0
+		}
+	case "in":
+		return containsValue(right, left)
+	case "contains":
+		return containsValue(left, right)
+	}
+	return false
+}
+
+// 数组包含元素，或字符串包含子串（不区分大小写）
+func containsValue(container, v interface{}) bool {
+	if s, ok := container.(string); ok {
+		sub, ok := v.(string)
+		return ok && strings.Contains(strings.ToLower(s), strings.ToLower(sub))
+	}
+	if _, ok := container.([]interface{}); ok {
+		return anyValue(container, func(e interface{}) bool { return equalValues(e, v) })
+	}
+	return false
+}
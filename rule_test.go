@@ -0,0 +1,147 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+// 测试用的条目，和 Emby 返回的 JSON 结构一致
+const ruleTestItem = `{
+	"Name": "The Matrix",
+	"Type": "Movie",
+	"ProductionYear": 1999,
+	"CommunityRating": 8.7,
+	"RunTimeTicks": 81600000000,
+	"OfficialRating": null,
+	"Genres": ["Action", "Science Fiction"],
+	"GenreItems": [{"Name": "Action", "Id": "1"}, {"Name": "Science Fiction", "Id": "2"}],
+	"Tags": [],
+	"UserData": {"Played": false, "PlayCount": 0}
+}`
+
+func ruleTestData(t *testing.T) map[string]interface{} {
+	t.Helper()
+	var item map[string]interface{}
+	if err := json.Unmarshal([]byte(ruleTestItem), &item); err != nil {
+		t.Fatal(err)
+	}
+	return item
+}
+
+func TestRuleMatch(t *testing.T) {
+	item := ruleTestData(t)
+	tests := []struct {
+		rule string
+		want bool
+	}{
+		// 优先级：! 高于 &&，&& 高于 ||
+		{`true || false && false`, true},
+		{`(true || false) && false`, false},
+		{`!false && false`, false},
+		{`!(false && false)`, true},
+		{`not UserData.Played and ProductionYear < 2000`, true},
+		{`ProductionYear > 2000 or CommunityRating >= 8.7`, true},
+		{`ProductionYear > 2000 || CommunityRating > 9 && true`, false},
+
+		// 比较
+		{`ProductionYear == 1999`, true},
+		{`ProductionYear != 1999`, false},
+		{`CommunityRating > 8.5 && CommunityRating <= 8.7`, true},
+		{`Name == "the matrix"`, true},
+		{`Type == 'Movie'`, true},
+		{`UserData.Played == false`, true},
+		{`UserData.Missing.Deep == null`, true},
+
+		// in / contains 作用于数组
+		{`Genres contains "action"`, true},
+		{`Genres contains "Drama"`, false},
+		{`GenreItems contains "Science Fiction"`, true},
+		{`"Action" in Genres`, true},
+		{`Type in ["Series", "Movie"]`, true},
+		{`Type in ["Series", "Episode"]`, false},
+		{`ProductionYear in [1998, 1999]`, true},
+		{`Genres == "Action"`, true},
+		{`Genres != "Drama"`, true},
+		{`Tags contains "x"`, false},
+		{`Tags`, false},
+		{`Name contains "MATRIX"`, true},
+
+		// 正则
+		{`Name =~ "^The "`, true},
+		{`Name =~ "^Matrix"`, false},
+		{`Name !~ "^Matrix"`, true},
+		{`Genres =~ "Fiction$"`, true},
+		{`GenreItems =~ "^Sci"`, true},
+		{`OfficialRating =~ "."`, false},
+
+		// null
+		{`OfficialRating == null`, true},
+		{`OfficialRating != null`, false},
+		{`Missing == null`, true},
+		{`Name != null`, true},
+		{`Missing < 10`, false},
+		{`Missing > 10`, false},
+		{`Missing`, false},
+		{`!Missing`, true},
+		{`Missing in ["a"]`, false},
+
+		// 计算字段 Runtime，单位分钟
+		{`Runtime == 136`, true},
+		{`Runtime < 120`, false},
+		{`Runtime > 120 && Runtime < 140`, true},
+	}
+	for _, tt := range tests {
+		r, err := compileRule(tt.rule)
+		if err != nil {
+			t.Errorf("compileRule(%q) error: %v", tt.rule, err)
+			continue
+		}
+		if got := r.Match(item); got != tt.want {
+			t.Errorf("%q = %v, want %v", tt.rule, got, tt.want)
+		}
+	}
+}
+
+func TestRuleRuntimeWithoutTicks(t *testing.T) {
+	r, err := compileRule(`Runtime == null && !(Runtime < 100)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !r.Match(map[string]interface{}{"Name": "No runtime"}) {
+		t.Error("Runtime should be null without RunTimeTicks")
+	}
+}
+
+func TestRuleSyntaxErrors(t *testing.T) {
+	tests := []string{
+		``,
+		`Name ==`,
+		`(Name == "a"`,
+		`Name == "a")`,
+		`Name == "unterminated`,
+		`Type in ["a", "b"`,
+		`Name =~ Type`,
+		`Name =~ "("`,
+		`Name == "a" &&`,
+		`ProductionYear # 1999`,
+		`Name "a"`,
+		`1.2.3 > 1`,
+	}
+	for _, rule := range tests {
+		if _, err := compileRule(rule); err == nil {
+			t.Errorf("compileRule(%q) should fail", rule)
+		}
+	}
+}
+
+func TestRuleFieldParams(t *testing.T) {
+	r, err := compileRule(`Runtime > 90 && Genres contains "Action" && GenreItems contains "Drama" && Name != "" && UserData.Played == false`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := r.fieldParams()
+	want := []string{"Genres"}
+	if len(got) != len(want) || got[0] != want[0] {
+		t.Errorf("fieldParams() = %v, want %v", got, want)
+	}
+}
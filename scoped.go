@@ -0,0 +1,106 @@
+package main
+
+import (
+	"maps"
+	"net/http"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const membershipBatchSize = 100
+
+// 保留属于虚拟库的条目，剧集按所属的剧判断
+func itemsInLibrary(lib *Library, items []interface{}, orignalReq *http.Request) []interface{} {
+	var ids []string
+	seen := map[string]bool{}
+	for _, item := range items {
+		m, _ := item.(map[string]interface{})
+		for _, key := range []string{"Id", "SeriesId"} {
+			if id, _ := m[key].(string); id != "" && !seen[id] {
+				seen[id] = true
+				ids = append(ids, id)
+			}
+		}
+	}
+	if len(ids) == 0 {
+		return items
+	}
+	members := map[string]bool{}
+	fetch := func(q url.Values) map[string]interface{} {
+		return fetchUserItems(q, orignalReq)
+	}
+	// 分批查询，避免 URL 过长
+	for batch := range slices.Chunk(ids, membershipBatchSize) {
+		query := url.Values{}
+		query.Set("Ids", strings.Join(batch, ","))
+		maps.Copy(members, collectItemIds(lib, query, fetch))
+	}
+	result := make([]interface{}, 0, len(items))
+	for _, item := range items {
+		m, _ := item.(map[string]interface{})
+		seriesId, _ := m["SeriesId"].(string)
+		if members[itemId(item)] || members[seriesId] {
+			result = append(result, item)
+		}
+	}
+	return result
+}
+
+var searchHintsRe = regexp.MustCompile(`/Search/Hints$`)
+
+// 虚拟库的继续观看：取用户全部在看的条目，只保留属于该库的
+func hookResume(resp *http.Response) error {
+	log.Debug("hookResume")
+	return hookScopedList(resp, "/Users/{userId}/Items/Resume", "Items")
+}
+
+// 虚拟库的接下来观看：Emby 的 NextUp 每部剧只返回一集，只保留库中的剧
+func hookNextUp(resp *http.Response) error {
+	log.Debug("hookNextUp")
+	return hookScopedList(resp, "/Shows/NextUp", "Items")
+}
+
+// 虚拟库内的搜索提示：全局搜索后只保留库中的条目
+func hookSearchHints(resp *http.Response) error {
+	log.Debug("hookSearchHints")
+	return hookScopedList(resp, "/Search/Hints", "SearchHints")
+}
+
+// 不带 ParentId 请求 path 得到用户的全部条目，过滤出属于虚拟库的条目后再分页
+// listKey 为响应中条目列表的字段名
+func hookScopedList(resp *http.Response, path, listKey string) error {
+	parentId := queryGet(resp.Request.URL.Query(), "ParentId")
+	cfg, libs := currentConfig()
+	lib, ok := lookupLibrary(libs, parentId)
+	if !ok {
+		return nil
+	}
+	if !libraryVisible(cfg, &lib, resp.Request) {
+		return refuseResponse(resp)
+	}
+	query := url.Values{}
+	forwardClientQuery(query, resp.Request.URL.Query())
+	startIndex, limit := query.Get("StartIndex"), query.Get("Limit")
+	// 过滤后再分页，否则该库的条目可能被其他库的条目挤出这一页
+	query.Del("StartIndex")
+	query.Del("Limit")
+	data := fetchUserJSON(path, query, resp.Request)
+	items, _ := data[listKey].([]interface{})
+	for _, item := range items {
+		// 搜索提示的 id 字段是 ItemId
+		if m, ok := item.(map[string]interface{}); ok && m["Id"] == nil {
+			m["Id"] = m["ItemId"]
+		}
+	}
+	items = itemsInLibrary(&lib, items, resp.Request)
+	log.Debugf("%s %s items: %d", path, lib.Name, len(items))
+	page := pageItems(items, startIndex, limit)
+	return replaceJSON(resp, map[string]interface{}{
+		listKey:            page["Items"],
+		"TotalRecordCount": page["TotalRecordCount"],
+	})
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// 路径中的 /Users/{userId}，前面可能有 /emby、/mediabrowser 或 base url，Emby 的路径不区分大小写
+var userPathRe = regexp.MustCompile(`(?i)/Users/([^/]+)`)
+
+// /Users/ 下不是用户 id 的路径
+var nonUserPathSegments = []string{"Me", "Public", "New", "Query", "Prefixes", "ItemAccess", "AuthenticateByName", "ForgotPassword"}
+
+// X-Emby-Authorization: Emby UserId="...", Client="...", Device="...", DeviceId="...", Version="...", Token="..."
+var authParamRe = regexp.MustCompile(`(?i)\b(\w+)="?([^",]*)"?`)
+
+// token -> userId，token 只能通过 Emby 反查用户，缓存一段时间
+var tokenUserCache = newTTLCache[string](10 * time.Minute)
+
+// 查不到唯一用户的 token（例如 API Key），只短暂缓存，避免每个请求都去查
+var tokenNoUserCache = newTTLCache[bool](30 * time.Second)
+
+// 认证头中的参数，Jellyfin 客户端会对值做 URL 编码
+func authHeaderParam(req *http.Request, key string) string {
+	for _, header := range []string{"X-Emby-Authorization", "Authorization"} {
+		for _, m := range authParamRe.FindAllStringSubmatch(req.Header.Get(header), -1) {
+			if !strings.EqualFold(m[1], key) {
+				continue
+			}
+			if value, err := url.QueryUnescape(m[2]); err == nil {
+				return value
+			}
+			return m[2]
+		}
+	}
+	return ""
+}
+
+// 请求携带的 token，依次取头、查询参数和认证头
+func requestToken(req *http.Request) string {
+	for _, key := range []string{"X-Emby-Token", "X-MediaBrowser-Token"} {
+		if token := req.Header.Get(key); token != "" {
+			return token
+		}
+	}
+	query := req.URL.Query()
+	for _, key := range []string{"X-Emby-Token", "api_key", "ApiKey"} {
+		if token := queryGet(query, key); token != "" {
+			return token
+		}
+	}
+	return authHeaderParam(req, "Token")
+}
+
+func requestDeviceId(req *http.Request) string {
+	if id := req.Header.Get("X-Emby-Device-Id"); id != "" {
+		return id
+	}
+	if id := queryGet(req.URL.Query(), "X-Emby-Device-Id"); id != "" {
+		return id
+	}
+	return authHeaderParam(req, "DeviceId")
+}
+
+// 当前请求的用户 id：先看路径，再看 UserId 参数（/Shows/NextUp、Jellyfin 10.9 起的很多接口），
+// 最后通过 token 向 Emby 查询
+func getUserId(req *http.Request) string {
+	for _, m := range userPathRe.FindAllStringSubmatch(req.URL.Path, -1) {
+		if !containsFold(nonUserPathSegments, m[1]) {
+			return m[1]
+		}
+	}
+	if userId := queryGet(req.URL.Query(), "UserId"); userId != "" {
+		return userId
+	}
+	if userId := authHeaderParam(req, "UserId"); userId != "" {
+		return userId
+	}
+	token := requestToken(req)
+	if token == "" {
+		return ""
+	}
+	if userId, ok := tokenUserCache.Load(token); ok {
+		return userId
+	}
+	if _, ok := tokenNoUserCache.Load(token); ok {
+		return ""
+	}
+	userId, err := lookupTokenUser(req)
+	if err != nil {
+		// Emby 暂时不可用时不缓存，下次请求重新查
+		log.Warn("lookupTokenUser error ", err)
+		return ""
+	}
+	if userId == "" {
+		tokenNoUserCache.Store(token, true)
+		log.Debug("token has no unique user")
+		return ""
+	}
+	tokenUserCache.Store(token, userId)
+	log.Debugf("token resolved to user %q", userId)
+	return userId
+}
+
+// 先查当前用户，旧版 Emby 没有 /Users/Me 时从会话列表中按设备找
+// 只有请求失败时返回 error，查询成功但没有唯一的用户时返回空字符串
+func lookupTokenUser(req *http.Request) (string, error) {
+	query := url.Values{}
+	headers := http.Header{}
+	setXEmbyParams(query, req.URL.Query(), headers, req.Header)
+	headers.Set("accept", "application/json")
+	cookies := req.Cookies()
+
+	var me map[string]interface{}
+	if err := getJSON(embyURL("/Users/Me", ""), query, headers, cookies, &me); err == nil {
+		if userId := toString(me["Id"]); userId != "" {
+			return userId, nil
+		}
+	}
+
+	deviceId := requestDeviceId(req)
+	if deviceId != "" {
+		query.Set("DeviceId", deviceId)
+	}
+	var sessions []map[string]interface{}
+	if err := getJSON(embyURL("/Sessions", ""), query, headers, cookies, &sessions); err != nil {
+		return "", err
+	}
+	// 没有设备 id 时，管理员的 token 能看到所有人的会话，只有唯一的用户才可信
+	userId := ""
+	for _, session := range sessions {
+		id := toString(session["UserId"])
+		if id == "" {
+			continue
+		}
+		if userId != "" && id != userId {
+			return "", nil
+		}
+		userId = id
+	}
+	return userId, nil
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// resource_type 对应的 Emby 条目类型，collection 只要求是文件夹
+var resourceItemTypes = map[string][]string{
+	"tag":    {"Tag"},
+	"genre":  {"Genre", "MusicGenre", "GameGenre"},
+	"studio": {"Studio"},
+	"person": {"Person"},
+}
+
+// 通过 API Key 按 id 批量查询 Emby 条目，返回 id -> 条目，不存在的 id 不在结果中
+func getItemsByIds(ids []string, apiKey string) (map[string]map[string]interface{}, error) {
+	result := map[string]map[string]interface{}{}
+	if len(ids) == 0 {
+		return result, nil
+	}
+	query := url.Values{}
+	query.Set("Ids", strings.Join(ids, ","))
+	query.Set("API_KEY", apiKey)
+	headers := http.Header{}
+	headers.Set("accept", "application/json")
+	data, err := doGetJSON(embyURL("/Items", ""), query, headers, nil)
+	if err != nil {
+		return nil, err
+	}
+	items, ok := data["Items"].([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("unexpected response from emby: %v", data)
+	}
+	for _, raw := range items {
+		item, ok := raw.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if id, ok := item["Id"].(string); ok {
+			result[id] = item
+		}
+	}
+	return result, nil
+}
+
+// 检查库的 resource_id 是否存在以及类型是否和 resource_type 一致
+func checkLibraryResource(lib Library, items map[string]map[string]interface{}) error {
+	if lib.ResourceID == "" {
+		return nil
+	}
+	item, ok := items[lib.ResourceID]
+	if !ok {
+		return fmt.Errorf("library %q: resource_id %s not found on emby server", lib.Name, lib.ResourceID)
+	}
+	itemType, _ := item["Type"].(string)
+	if lib.ResourceType == "collection" {
+		if isFolder, _ := item["IsFolder"].(bool); !isFolder {
+			return fmt.Errorf("library %q: resource_id %s is a %s, not a collection", lib.Name, lib.ResourceID, itemType)
+		}
+		return nil
+	}
+	if types, ok := resourceItemTypes[lib.ResourceType]; ok && !slices.Contains(types, itemType) {
+		return fmt.Errorf("library %q: resource_id %s is a %s, not a %s", lib.Name, lib.ResourceID, itemType, lib.ResourceType)
+	}
+	return nil
+}
+
+// 检查虚拟库 id（包括旧 id）是否和 Emby 真实条目 id 冲突
+func checkLibraryIDCollisions(cfg *Config) ([]error, error) {
+	var ids []string
+	for _, lib := range cfg.Library {
+		ids = append(ids, lib.VirtualID())
+		ids = append(ids, lib.AliasIDs()...)
+	}
+	items, err := getItemsByIds(ids, cfg.EmbyApiKey)
+	if err != nil {
+		return nil, err
+	}
+	var errs []error
+	for _, lib := range cfg.Library {
+		for _, id := range append([]string{lib.VirtualID()}, lib.AliasIDs()...) {
+			if item, ok := items[id]; ok {
+				errs = append(errs, fmt.Errorf("library %q: id %s collides with emby item %q, set a different id", lib.Name, id, item["Name"]))
+			}
+		}
+	}
+	return errs, nil
+}
+
+// 启动和热重载时检查虚拟库 id 冲突，只记录日志
+func warnLibraryIDCollisions(cfg *Config) {
+	if cfg.EmbyApiKey == "" {
+		return
+	}
+	errs, err := checkLibraryIDCollisions(cfg)
+	if err != nil {
+		log.Warn("check library id collisions error ", err)
+		return
+	}
+	for _, err := range errs {
+		log.Error(err)
+	}
+}
+
+// validate 子命令：加载配置并通过 Emby API 逐个解析虚拟库，有问题时返回非零退出码
+func runValidate(args []string) int {
+	newFlagSet("validate", &opts).Parse(args)
+
+	cfg, err := readConfig(opts.ConfigPath)
+	if err != nil {
+		fmt.Println("load config error:", err)
+		return 1
+	}
+	selectServerFlavor(cfg.ServerType)
+	applyConfig(cfg)
+
+	problems := configErrors(cfg)
+	if cfg.EmbyApiKey == "" {
+		problems = append(problems, fmt.Errorf("emby_api_key is required to validate libraries against emby server"))
+	} else {
+		problems = append(problems, checkLibrariesOnServer(cfg)...)
+	}
+
+	for _, lib := range cfg.Library {
+		fmt.Printf("%s\t%s", lib.VirtualID(), lib.Name)
+		if aliases := lib.AliasIDs(); len(aliases) > 0 {
+			fmt.Printf(" (aliases: %s)", strings.Join(aliases, ", "))
+		}
+		fmt.Println()
+	}
+	for _, p := range problems {
+		fmt.Println(p)
+	}
+	if len(problems) > 0 {
+		fmt.Printf("%d problem(s) found in %s\n", len(problems), opts.ConfigPath)
+		return 1
+	}
+	fmt.Printf("%d libraries ok\n", len(cfg.Library))
+	return 0
+}
+
+// 展开 composite 库，返回所有需要在服务器上解析的单一来源，来源的 Name 标明其位置
+func resourceLibraries(lib Library) []Library {
+	if !lib.IsComposite() {
+		return []Library{lib}
+	}
+	var result []Library
+	lib.walkSources(func(src *Library, path string) {
+		s := *src
+		s.Name = lib.Name + "." + path
+		result = append(result, resourceLibraries(s)...)
+	})
+	return result
+}
+
+func checkLibrariesOnServer(cfg *Config) []error {
+	var resources []Library
+	for _, lib := range cfg.Library {
+		resources = append(resources, resourceLibraries(lib)...)
+	}
+	var ids []string
+	for _, lib := range resources {
+		if lib.ResourceID != "" {
+			ids = append(ids, lib.ResourceID)
+		}
+	}
+	items, err := getItemsByIds(ids, cfg.EmbyApiKey)
+	if err != nil {
+		return []error{fmt.Errorf("query emby server error: %w", err)}
+	}
+	var errs []error
+	for _, lib := range resources {
+		if err := checkLibraryResource(lib, items); err != nil {
+			errs = append(errs, err)
+		}
+		if lib.IsQuery() {
+			query := url.Values{}
+			query.Set("Limit", "0")
+			lib.applySource(query)
+			if fetchApiItems(query, cfg.EmbyApiKey) == nil {
+				errs = append(errs, fmt.Errorf("library %q: query rejected by emby server", lib.Name))
+			}
+		}
+	}
+	collisions, err := checkLibraryIDCollisions(cfg)
+	if err != nil {
+		return append(errs, fmt.Errorf("query emby server error: %w", err))
+	}
+	return append(errs, collisions...)
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// userId -> 用户名，用户名几乎不会变，缓存到进程退出
+var userNameCache sync.Map
+
+// 通过 Emby API 解析用户名，有 API Key 时用 API Key，否则沿用原始请求的认证信息
+func getUserName(userId string, orignalReq *http.Request) string {
+	if userId == "" {
+		return ""
+	}
+	if name, ok := userNameCache.Load(userId); ok {
+		return name.(string)
+	}
+	cfg, _ := currentConfig()
+	query := url.Values{}
+	headers := http.Header{}
+	headers.Set("accept", "application/json")
+	var cookies []*http.Cookie
+	if cfg.EmbyApiKey != "" {
+		query.Set("API_KEY", cfg.EmbyApiKey)
+	} else {
+		setXEmbyParams(query, orignalReq.URL.Query(), headers, orignalReq.Header)
+		cookies = orignalReq.Cookies()
+	}
+	data, err := doGetJSON(embyURL("/Users/{userId}", userId), query, headers, cookies)
+	if err != nil {
+		log.Warn("getUserName error ", err)
+		return ""
+	}
+	name, _ := data["Name"].(string)
+	if name != "" {
+		userNameCache.Store(userId, name)
+	}
+	return name
+}
+
+// 判断当前请求的用户是否命中规则列表，规则可以是用户 id、用户名或 @用户组
+func userMatches(cfg *Config, rules []string, req *http.Request) bool {
+	if len(rules) == 0 {
+		return false
+	}
+	userId := getUserId(req)
+	if userId == "" {
+		return false
+	}
+	var name string
+	nameResolved := false
+	var match func(rules []string, depth int) bool
+	match = func(rules []string, depth int) bool {
+		for _, rule := range rules {
+			if group, ok := strings.CutPrefix(rule, "@"); ok {
+				// 用户组不允许无限嵌套
+				if depth < 4 && match(cfg.UserGroups[group], depth+1) {
+					return true
+				}
+				continue
+			}
+			if rule == userId {
+				return true
+			}
+			if !nameResolved {
+				name = getUserName(userId, req)
+				nameResolved = true
+			}
+			if name != "" && strings.EqualFold(rule, name) {
+				return true
+			}
+		}
+		return false
+	}
+	return match(rules, 0)
+}
+
+// 虚拟库对当前请求的用户是否可见，限制了用户的库在无法确定用户时不可见
+func libraryVisible(cfg *Config, lib *Library, req *http.Request) bool {
+	if len(lib.AllowUsers) == 0 && len(lib.DenyUsers) == 0 {
+		return true
+	}
+	if getUserId(req) == "" {
+		return false
+	}
+	if len(lib.AllowUsers) > 0 && !userMatches(cfg, lib.AllowUsers, req) {
+		return false
+	}
+	return !userMatches(cfg, lib.DenyUsers, req)
+}
+
+// 当前请求的用户要隐藏的真实库类型，命中的第一条 user_hide 规则优先于全局 hide，
+// 无法确定用户时隐藏全局 hide 和所有 user_hide 规则中的类型
+func hideListFor(cfg *Config, req *http.Request) []string {
+	if len(cfg.UserHide) > 0 && getUserId(req) == "" {
+		hide := slices.Clone(cfg.Hide)
+		for _, rule := range cfg.UserHide {
+			hide = append(hide, rule.Hide...)
+		}
+		return hide
+	}
+	for _, rule := range cfg.UserHide {
+		if userMatches(cfg, rule.Users, req) {
+			return rule.Hide
+		}
+	}
+	return cfg.Hide
+}
+
+// 真实库是否需要隐藏
+func shouldHideView(hide []string, item map[string]interface{}) bool {
+	if slices.Contains(hide, "all") {
+		return true
+	}
+	collectionType, _ := item["CollectionType"].(string)
+	return slices.Contains(hide, collectionType)
+}
+
+// 对不可见的虚拟库按不存在处理
+func refuseResponse(resp *http.Response) error {
+	if resp.Body != nil {
+		resp.Body.Close()
+	}
+	resp.Header.Set("Content-Type", "text/plain")
+	resp.Header.Del("Content-Encoding")
+	resp.StatusCode = http.StatusNotFound
+	resp.Status = "404 Not Found"
+	return writeBody(resp, []byte("Item not found"))
+}
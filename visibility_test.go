@@ -0,0 +1,59 @@
+package main
+
+import (
+	"net/http/httptest"
+	"slices"
+	"testing"
+)
+
+func TestLibraryVisible(t *testing.T) {
+	// 用户名已缓存，不会请求 Emby
+	userNameCache.Store("kid1", "kid")
+	userNameCache.Store("adult1", "adult")
+	cfg := &Config{UserGroups: map[string][]string{"kids": {"kid"}}}
+	tests := []struct {
+		name string
+		lib  Library
+		path string
+		want bool
+	}{
+		{"no rules", Library{}, "/Library/MediaFolders", true},
+		{"deny matched", Library{DenyUsers: []string{"@kids"}}, "/Users/kid1/Views", false},
+		{"deny not matched", Library{DenyUsers: []string{"@kids"}}, "/Users/adult1/Views", true},
+		{"allow matched", Library{AllowUsers: []string{"kid1"}}, "/Users/kid1/Views", true},
+		{"allow not matched", Library{AllowUsers: []string{"kid1"}}, "/Users/adult1/Views", false},
+		// 无法确定用户时，限制了用户的库一律不可见
+		{"deny unresolved", Library{DenyUsers: []string{"kid"}}, "/Library/MediaFolders", false},
+		{"allow unresolved", Library{AllowUsers: []string{"adult"}}, "/Library/MediaFolders", false},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", tt.path, nil)
+		if got := libraryVisible(cfg, &tt.lib, req); got != tt.want {
+			t.Errorf("%s: libraryVisible() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestHideListFor(t *testing.T) {
+	userNameCache.Store("kid1", "kid")
+	userNameCache.Store("adult1", "adult")
+	cfg := &Config{
+		Hide:     []string{"music"},
+		UserHide: []UserHide{{Users: []string{"kid"}, Hide: []string{"movies", "tvshows"}}},
+	}
+	tests := []struct {
+		path string
+		want []string
+	}{
+		{"/Users/kid1/Views", []string{"movies", "tvshows"}},
+		{"/Users/adult1/Views", []string{"music"}},
+		// 无法确定用户时取所有规则的并集
+		{"/Library/MediaFolders", []string{"music", "movies", "tvshows"}},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", tt.path, nil)
+		if got := hideListFor(cfg, req); !slices.Equal(got, tt.want) {
+			t.Errorf("%s: hideListFor() = %v, want %v", tt.path, got, tt.want)
+		}
+	}
+}